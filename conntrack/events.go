@@ -1,6 +1,7 @@
 package conntrack
 
 import (
+	"conntrack-lanrtt-analysis/exporter"
 	"conntrack-lanrtt-analysis/loader"
 	"conntrack-lanrtt-analysis/metrics"
 	"errors"
@@ -24,11 +25,12 @@ type event struct {
 	FlowID          string
 }
 
-func handleOutput(output string, regex *regexp.Regexp, eventMap map[string]map[string]interface{}, allFlows *[]metrics.Flow, deviceFlows map[string][]float64, arguments *loader.Args, mux *sync.Mutex) error {
+var errNoRegexMatch = errors.New("no regex match for conntrack output")
+
+func handleOutput(output string, regex *regexp.Regexp, handler func(event) error) error {
 	matches := regex.FindAllStringSubmatch(output, -1)
 	if matches == nil {
-		errorMsg := "no regex match for conntrack output: " + output + "/n"
-		return errors.New(errorMsg)
+		return errNoRegexMatch
 	}
 	for _, match := range matches {
 		if len(match) != 13 {
@@ -53,7 +55,7 @@ func handleOutput(output string, regex *regexp.Regexp, eventMap map[string]map[s
 			newEvent.ReplyDstPort = match[11]
 			newEvent.FlowID = match[12]
 
-			err = processNewEvent(newEvent, eventMap, allFlows, deviceFlows, arguments, mux)
+			err = handler(newEvent)
 			if err != nil {
 				return err
 			}
@@ -68,14 +70,20 @@ func parseTimestamp(part1, part2 string) (float64, error) {
 	return strconv.ParseFloat(combined, 64)
 }
 
-func processNewEvent(newEvent event, eventMap map[string]map[string]interface{}, allFlows *[]metrics.Flow, deviceFlows map[string][]float64, arguments *loader.Args, mux *sync.Mutex) error {
+func processNewEvent(newEvent event, eventMap *handshakes, allFlows *metrics.FlowBuffer, deviceFlows map[string][]float64, observer *metrics.FlowObserver, filter *eventFilter, arguments *loader.Args, mux *sync.Mutex) error {
+
+	eventMap.expire(newEvent.TimeStamp)
+
+	if !filter.allowed(newEvent) {
+		return nil
+	}
 
 	switch newEvent.PacketType {
 	case "SYN_RECV":
 		handleSynRecvEvent(newEvent, eventMap)
 
 	case "ESTABLISHED":
-		handleAckEvent(newEvent, eventMap, allFlows, deviceFlows, arguments.BufferSize, mux)
+		handleAckEvent(newEvent, eventMap, allFlows, deviceFlows, observer, arguments.BufferSize, mux)
 	default:
 		return errors.New("no valid event type")
 	}
@@ -93,8 +101,8 @@ func logEvent(newEvent event) {
 		newEvent.ReplyDst, newEvent.ReplySrcPort, newEvent.ReplyDstPort, newEvent.FlowID)
 }
 
-func handleSynRecvEvent(newEvent event, eventMap map[string]map[string]interface{}) {
-	eventMap[newEvent.FlowID] = map[string]interface{}{
+func handleSynRecvEvent(newEvent event, eventMap *handshakes) {
+	eventMap.add(newEvent.FlowID, newEvent.TimeStamp, map[string]interface{}{
 		"timestamp":   newEvent.TimeStamp,
 		"type":        newEvent.PacketType,
 		"origSrc":     newEvent.OriginalSrc,
@@ -105,18 +113,17 @@ func handleSynRecvEvent(newEvent event, eventMap map[string]map[string]interface
 		"replyDst":    newEvent.ReplyDst,
 		"replySport":  newEvent.ReplySrcPort,
 		"replyDsport": newEvent.ReplyDstPort,
-	}
+	})
 }
 
-func handleAckEvent(newEvent event, eventMap map[string]map[string]interface{}, allFlows *[]metrics.Flow, deviceFlows map[string][]float64, bufferSize int, mux *sync.Mutex) {
-	synRecvEvent, present := eventMap[newEvent.FlowID]
+func handleAckEvent(newEvent event, eventMap *handshakes, allFlows *metrics.FlowBuffer, deviceFlows map[string][]float64, observer *metrics.FlowObserver, bufferSize int, mux *sync.Mutex) {
+	synRecvEvent, present := eventMap.match(newEvent.FlowID)
 	if present {
-		processMatchedEvent(newEvent.TimeStamp, newEvent.FlowID, newEvent.OriginalSrc, synRecvEvent, allFlows, deviceFlows, bufferSize, mux)
-		delete(eventMap, newEvent.FlowID)
+		processMatchedEvent(newEvent.TimeStamp, newEvent.FlowID, newEvent.OriginalSrc, synRecvEvent, allFlows, deviceFlows, observer, bufferSize, mux, eventMap.promMetrics)
 	}
 }
 
-func processMatchedEvent(timestamp float64, flowID, origSrc string, event map[string]interface{}, allFlows *[]metrics.Flow, deviceFlows map[string][]float64, bufferSize int, mux *sync.Mutex) {
+func processMatchedEvent(timestamp float64, flowID, origSrc string, event map[string]interface{}, allFlows *metrics.FlowBuffer, deviceFlows map[string][]float64, observer *metrics.FlowObserver, bufferSize int, mux *sync.Mutex, promMetrics *exporter.PromMetrics) {
 	synTimestamp := event["timestamp"].(float64)
 	lanRTT := metrics.CalculateFlowRtt(synTimestamp, timestamp)
 
@@ -128,12 +135,13 @@ func processMatchedEvent(timestamp float64, flowID, origSrc string, event map[st
 		LanRTT:       lanRTT,
 	}
 
+	observer.Observe(newFlow)
+
 	mux.Lock()
 
-	if len(*allFlows) == bufferSize {
-		*allFlows = (*allFlows)[1:]
+	if evicted := allFlows.Add(newFlow, bufferSize); evicted > 0 {
+		promMetrics.BufferEvictions.Add(float64(evicted))
 	}
-	*allFlows = append(*allFlows, newFlow)
 	updateDeviceFlows(origSrc, lanRTT, deviceFlows)
 	mux.Unlock()
 }
@@ -1,12 +1,18 @@
 package conntrack
 
 import (
+	"conntrack-lanrtt-analysis/exporter"
 	"conntrack-lanrtt-analysis/loader"
 	"conntrack-lanrtt-analysis/metrics"
 	"errors"
+	"math"
 	"regexp"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestHandleOutput(t *testing.T) {
@@ -33,6 +39,11 @@ func TestHandleOutput(t *testing.T) {
 			output:        "[1702972533.349065]	 [UPDATE] tcp      120 FIN_WAIT src=10.152.4.231 dst=173.222.210.216 sport=51679 dport=443 src=173.222.210.216 dst=31.205.218.167",
 			expectedError: errors.New("no regex match for conntrack output"),
 		},
+		{
+			name:          "ValidOutputIPv6",
+			output:        "[1702972534.120044]	 [UPDATE] tcp      6 432000 ESTABLISHED src=2001:db8:0:1::2a dst=2606:4700::1111 sport=51680 dport=443 src=2606:4700::1111 dst=2001:db8:0:1::2a sport=443 dport=51680 [ASSURED] id=2858042688",
+			expectedError: nil,
+		},
 		{
 			name:          "ValidOutput",
 			output:        "[1702972533.997256]	 [UPDATE] tcp      6 60 SYN_RECV src=10.152.11.29 dst=61.170.79.234 sport=58765 dport=443 src=61.170.79.234 dst=31.205.218.180 sport=443 dport=58765 id=2857185344",
@@ -44,13 +55,19 @@ func TestHandleOutput(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 
 			// empty mocks
-			eventMap := make(map[string]map[string]interface{})
-			var flows []metrics.Flow
+			eventMap := newHandshakes(30, 100, exporter.BuildPromMetrics(prometheus.NewRegistry()))
+			var flows metrics.FlowBuffer
 			deviceFlows := make(map[string][]float64)
+			observer := metrics.NewFlowObserver(metrics.NewWindowedSketch(60), exporter.BuildPromMetrics(prometheus.NewRegistry()))
+			filter := newEventFilter(nil, nil)
 			arguments := &loader.Args{}
 			mux := &sync.Mutex{}
 
-			err := handleOutput(tc.output, regex, eventMap, &flows, deviceFlows, arguments, mux)
+			handler := func(newEvent event) error {
+				return processNewEvent(newEvent, eventMap, &flows, deviceFlows, observer, filter, arguments, mux)
+			}
+
+			err := handleOutput(tc.output, regex, handler)
 
 			if (err != nil && tc.expectedError == nil) || (err == nil && tc.expectedError != nil) || (err != nil && tc.expectedError != nil && err.Error() != tc.expectedError.Error()) {
 				t.Errorf("Test %v: Expected error %v, got %v", tc.name, tc.expectedError, err)
@@ -129,12 +146,14 @@ func TestProcessNewEvent(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			eventMap := make(map[string]map[string]interface{})
-			var flows []metrics.Flow
+			eventMap := newHandshakes(30, 100, exporter.BuildPromMetrics(prometheus.NewRegistry()))
+			var flows metrics.FlowBuffer
 			deviceFlows := make(map[string][]float64)
+			observer := metrics.NewFlowObserver(metrics.NewWindowedSketch(60), exporter.BuildPromMetrics(prometheus.NewRegistry()))
+			filter := newEventFilter(nil, nil)
 			mux := &sync.Mutex{}
 
-			err := processNewEvent(tc.newEvent, eventMap, &flows, deviceFlows, tc.arguments, mux)
+			err := processNewEvent(tc.newEvent, eventMap, &flows, deviceFlows, observer, filter, tc.arguments, mux)
 
 			if (err != nil && tc.expectedError == nil) || (err == nil && tc.expectedError != nil) || (err != nil && tc.expectedError != nil && err.Error() != tc.expectedError.Error()) {
 				t.Errorf("Test %s: expected error %v, got %v", tc.name, tc.expectedError, err)
@@ -143,3 +162,101 @@ func TestProcessNewEvent(t *testing.T) {
 		})
 	}
 }
+
+func TestMixedFamilyStream(t *testing.T) {
+	regex := compileEventRegex()
+	stream := []string{
+		"[1702972533.100000]	 [UPDATE] tcp      6 60 SYN_RECV src=10.152.4.231 dst=173.222.210.216 sport=51679 dport=443 src=173.222.210.216 dst=31.205.218.167 sport=443 dport=51679 id=100",
+		"[1702972533.105000]	 [UPDATE] tcp      6 60 SYN_RECV src=2001:db8:0:1::2a dst=2606:4700::1111 sport=51680 dport=443 src=2606:4700::1111 dst=2001:db8:0:1::2a sport=443 dport=51680 id=200",
+		"[1702972533.110000]	 [UPDATE] tcp      6 432000 ESTABLISHED src=10.152.4.231 dst=173.222.210.216 sport=51679 dport=443 src=173.222.210.216 dst=31.205.218.167 sport=443 dport=51679 [ASSURED] id=100",
+		"[1702972533.125000]	 [UPDATE] tcp      6 432000 ESTABLISHED src=2001:db8:0:1::2a dst=2606:4700::1111 sport=51680 dport=443 src=2606:4700::1111 dst=2001:db8:0:1::2a sport=443 dport=51680 [ASSURED] id=200",
+	}
+
+	promMetrics := exporter.BuildPromMetrics(prometheus.NewRegistry())
+	families := metrics.NewGroupedSeries("Family", metrics.AddressFamily, 60, promMetrics.Family)
+	observer := metrics.NewFlowObserver(metrics.NewWindowedSketch(60), promMetrics, families)
+	filter := newEventFilter(nil, promMetrics)
+	eventMap := newHandshakes(30, 100, promMetrics)
+	var flows metrics.FlowBuffer
+	deviceFlows := make(map[string][]float64)
+	arguments := &loader.Args{BufferSize: 10}
+	mux := &sync.Mutex{}
+
+	handler := func(newEvent event) error {
+		return processNewEvent(newEvent, eventMap, &flows, deviceFlows, observer, filter, arguments, mux)
+	}
+
+	for _, output := range stream {
+		if err := handleOutput(output, regex, handler); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	if flows.Len() != 2 {
+		t.Fatalf("expected 2 matched flows, got %d", flows.Len())
+	}
+
+	metrics.CalculateGroups(time.Now(), []*metrics.GroupedSeries{families}, deviceFlows, []float64{0.5}, false)
+
+	expectedMeans := map[string]float64{"ipv4": 10, "ipv6": 20}
+	for family, expectedMean := range expectedMeans {
+		mean := testutil.ToFloat64(promMetrics.Family.Mean.WithLabelValues(family))
+		if math.Abs(mean-expectedMean) > 0.001 {
+			t.Errorf("expected %s mean %v, got %v", family, expectedMean, mean)
+		}
+		devices := testutil.ToFloat64(promMetrics.Family.DeviceCount.WithLabelValues(family))
+		if devices != 1 {
+			t.Errorf("expected 1 %s device, got %v", family, devices)
+		}
+	}
+}
+
+func TestEventCounters(t *testing.T) {
+	regex := compileEventRegex()
+	stream := []string{
+		"[1702972533.100000]	 [UPDATE] tcp      6 60 SYN_RECV src=10.152.4.231 dst=173.222.210.216 sport=51679 dport=443 src=173.222.210.216 dst=31.205.218.167 sport=443 dport=51679 id=100",
+		"[1702972533.101000]	 [UPDATE] tcp      6 60 SYN_RECV src=10.152.4.231 dst=173.222.210.216 sport=51680 dport=443 src=173.222.210.216 dst=31.205.218.167 sport=443 dport=51680 id=101",
+		"conntrack v1.4.6 (conntrack-tools): 2 flow events have been shown.",
+		"[1702972533.110000]	 [UPDATE] tcp      6 432000 ESTABLISHED src=10.152.4.231 dst=173.222.210.216 sport=51679 dport=443 src=173.222.210.216 dst=31.205.218.167 sport=443 dport=51679 [ASSURED] id=100",
+		"[1702972533.115000]	 [UPDATE] tcp      6 432000 ESTABLISHED src=10.152.4.231 dst=173.222.210.216 sport=51680 dport=443 src=173.222.210.216 dst=31.205.218.167 sport=443 dport=51680 [ASSURED] id=101",
+		"[1702972533.120000]	 [UPDATE] tcp      6 432000 ESTABLISHED src=10.152.4.231 dst=173.222.210.216 sport=51681 dport=443 src=173.222.210.216 dst=31.205.218.167 sport=443 dport=51681 [ASSURED] id=102",
+	}
+
+	// a one flow buffer, the second matched flow pushes the first out
+	c := newTestCapture(t, 1)
+	promMetrics := c.promMetrics
+
+	for _, output := range stream {
+		processLine(output, regex, c.handle, false, promMetrics)
+	}
+
+	expected := map[string]struct {
+		counter  prometheus.Collector
+		expected float64
+	}{
+		"lines":       {promMetrics.ConntrackLines, 6},
+		"unparsed":    {promMetrics.UnparsedLines, 1},
+		"syn_recv":    {promMetrics.Events.WithLabelValues("SYN_RECV"), 2},
+		"established": {promMetrics.Events.WithLabelValues("ESTABLISHED"), 3},
+		"matched":     {promMetrics.HandshakesMatched, 2},
+		"unmatched":   {promMetrics.UnmatchedEstablished, 1},
+		"evictions":   {promMetrics.BufferEvictions, 1},
+	}
+	for name, counter := range expected {
+		if got := testutil.ToFloat64(counter.counter); got != counter.expected {
+			t.Errorf("expected %s %v, got %v", name, counter.expected, got)
+		}
+	}
+}
+
+// newTestCapture is a capture with a bufferSize flow buffer and its own metrics registry
+func newTestCapture(t *testing.T, bufferSize int) *capture {
+	t.Helper()
+
+	arguments := &loader.Args{BufferSize: bufferSize, HandshakeTTL: 30, MaxPending: 100, QuantileWin: 60}
+	c, err := newCapture(arguments, exporter.BuildPromMetrics(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return c
+}
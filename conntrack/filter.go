@@ -0,0 +1,63 @@
+package conntrack
+
+import (
+	"conntrack-lanrtt-analysis/exporter"
+	"conntrack-lanrtt-analysis/loader"
+	"net"
+	"strconv"
+)
+
+// eventFilter applies the configured allow/deny rules to each event before it reaches the flow matcher
+type eventFilter struct {
+	rules       []loader.FilterRule
+	promMetrics *exporter.PromMetrics
+}
+
+func newEventFilter(rules []loader.FilterRule, promMetrics *exporter.PromMetrics) *eventFilter {
+	return &eventFilter{rules: rules, promMetrics: promMetrics}
+}
+
+// allowed reports whether newEvent should be recorded, events matching no rule are allowed
+func (f *eventFilter) allowed(newEvent event) bool {
+	if len(f.rules) == 0 {
+		return true
+	}
+
+	src := net.ParseIP(newEvent.OriginalSrc)
+	dst := net.ParseIP(newEvent.OriginalDst)
+	port, _ := strconv.Atoi(newEvent.OriginalDstPort)
+
+	for _, rule := range f.rules {
+		if !ruleMatches(rule, src, dst, port) {
+			continue
+		}
+		if !rule.Allow {
+			f.promMetrics.FilteredEvents.WithLabelValues(rule.Name).Inc()
+		}
+		return rule.Allow
+	}
+
+	return true
+}
+
+func ruleMatches(rule loader.FilterRule, src, dst net.IP, port int) bool {
+	if len(rule.Sources) > 0 && !anyContains(rule.Sources, src) {
+		return false
+	}
+	if len(rule.Destinations) > 0 && !anyContains(rule.Destinations, dst) {
+		return false
+	}
+	if len(rule.Ports) > 0 && !rule.Ports[port] {
+		return false
+	}
+	return true
+}
+
+func anyContains(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
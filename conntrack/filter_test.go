@@ -0,0 +1,72 @@
+package conntrack
+
+import (
+	"conntrack-lanrtt-analysis/exporter"
+	"conntrack-lanrtt-analysis/loader"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestEventFilter(t *testing.T) {
+	rules, err := loader.ParseRules([]loader.Rule{
+		{Name: "printer-allowed", Action: "allow", Sources: []string{"192.168.0.50/32"}, Ports: []int{443}},
+		{Name: "infrastructure", Action: "deny", Sources: []string{"192.168.0.1/32", "192.168.0.50/31"}},
+		{Name: "dns", Action: "deny", Ports: []int{53, 853}},
+		{Name: "local-servers", Action: "deny", Destinations: []string{"10.0.0.0/8"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	promMetrics := exporter.BuildPromMetrics(prometheus.NewRegistry())
+	filter := newEventFilter(rules, promMetrics)
+
+	testCases := []struct {
+		name            string
+		newEvent        event
+		expectedAllowed bool
+	}{
+		{
+			name:            "NoRuleMatches",
+			newEvent:        event{OriginalSrc: "192.168.0.20", OriginalDst: "1.1.1.1", OriginalDstPort: "443"},
+			expectedAllowed: true,
+		},
+		{
+			name:            "AllowBeforeDeny",
+			newEvent:        event{OriginalSrc: "192.168.0.50", OriginalDst: "1.1.1.1", OriginalDstPort: "443"},
+			expectedAllowed: true,
+		},
+		{
+			name:            "DeniedSource",
+			newEvent:        event{OriginalSrc: "192.168.0.51", OriginalDst: "1.1.1.1", OriginalDstPort: "443"},
+			expectedAllowed: false,
+		},
+		{
+			name:            "DeniedPort",
+			newEvent:        event{OriginalSrc: "192.168.0.20", OriginalDst: "1.1.1.1", OriginalDstPort: "853"},
+			expectedAllowed: false,
+		},
+		{
+			name:            "DeniedDestination",
+			newEvent:        event{OriginalSrc: "192.168.0.20", OriginalDst: "10.1.2.3", OriginalDstPort: "443"},
+			expectedAllowed: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if allowed := filter.allowed(tc.newEvent); allowed != tc.expectedAllowed {
+				t.Errorf("Test %s: expected allowed %v, got %v", tc.name, tc.expectedAllowed, allowed)
+			}
+		})
+	}
+
+	expectedCounts := map[string]float64{"printer-allowed": 0, "infrastructure": 1, "dns": 1, "local-servers": 1}
+	for rule, expected := range expectedCounts {
+		if got := testutil.ToFloat64(promMetrics.FilteredEvents.WithLabelValues(rule)); got != expected {
+			t.Errorf("expected %v filtered events for rule %s, got %v", expected, rule, got)
+		}
+	}
+}
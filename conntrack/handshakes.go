@@ -0,0 +1,94 @@
+package conntrack
+
+import (
+	"conntrack-lanrtt-analysis/exporter"
+	"sync/atomic"
+)
+
+type pendingHandshake struct {
+	flowID    string
+	timestamp float64
+}
+
+// handshakes holds SYN_RECV events waiting for their ESTABLISHED. Entries older than maxAge seconds
+// (by conntrack timestamp) are expired and the oldest entry is dropped once maxPending is reached
+type handshakes struct {
+	// read by the service notifier from another goroutine, 64-bit aligned first for atomic access
+	matched uint64
+	pending int64
+
+	events      map[string]map[string]interface{}
+	order       []pendingHandshake
+	maxAge      float64
+	maxPending  int
+	promMetrics *exporter.PromMetrics
+}
+
+func newHandshakes(maxAge float64, maxPending int, promMetrics *exporter.PromMetrics) *handshakes {
+	return &handshakes{
+		events:      make(map[string]map[string]interface{}),
+		order:       make([]pendingHandshake, 0, 10),
+		maxAge:      maxAge,
+		maxPending:  maxPending,
+		promMetrics: promMetrics,
+	}
+}
+
+func (h *handshakes) add(flowID string, timestamp float64, synRecvEvent map[string]interface{}) {
+	if _, present := h.events[flowID]; !present && len(h.events) >= h.maxPending {
+		h.evictOldest("overflow")
+	}
+
+	h.events[flowID] = synRecvEvent
+	h.order = append(h.order, pendingHandshake{flowID: flowID, timestamp: timestamp})
+	h.updatePending()
+}
+
+func (h *handshakes) match(flowID string) (map[string]interface{}, bool) {
+	synRecvEvent, present := h.events[flowID]
+	if present {
+		delete(h.events, flowID)
+		atomic.AddUint64(&h.matched, 1)
+		h.promMetrics.HandshakesMatched.Inc()
+		h.updatePending()
+	} else {
+		h.promMetrics.UnmatchedEstablished.Inc()
+	}
+	return synRecvEvent, present
+}
+
+// expire evicts every pending handshake that started more than maxAge seconds before now
+func (h *handshakes) expire(now float64) {
+	for len(h.order) > 0 && h.order[0].timestamp < now-h.maxAge {
+		h.evict(h.order[0], "expired")
+		h.order = h.order[1:]
+	}
+	h.updatePending()
+}
+
+func (h *handshakes) evictOldest(reason string) {
+	for len(h.order) > 0 {
+		oldest := h.order[0]
+		h.order = h.order[1:]
+		if h.evict(oldest, reason) {
+			return
+		}
+	}
+}
+
+// evict removes entry if it is still pending, entries already matched or replaced by a retransmitted SYN_RECV are skipped
+func (h *handshakes) evict(entry pendingHandshake, reason string) bool {
+	synRecvEvent, present := h.events[entry.flowID]
+	if !present || synRecvEvent["timestamp"].(float64) != entry.timestamp {
+		return false
+	}
+
+	delete(h.events, entry.flowID)
+	h.promMetrics.HandshakesEvicted.WithLabelValues(reason).Inc()
+	return true
+}
+
+func (h *handshakes) updatePending() {
+	atomic.StoreInt64(&h.pending, int64(len(h.events)))
+	h.promMetrics.HandshakesPending.Set(float64(len(h.events)))
+}
@@ -0,0 +1,82 @@
+package conntrack
+
+import (
+	"conntrack-lanrtt-analysis/exporter"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func synRecv(timestamp float64) map[string]interface{} {
+	return map[string]interface{}{"timestamp": timestamp}
+}
+
+func TestHandshakesExpire(t *testing.T) {
+	promMetrics := exporter.BuildPromMetrics(prometheus.NewRegistry())
+	pending := newHandshakes(30, 100, promMetrics)
+
+	pending.add("1", 100, synRecv(100))
+	pending.add("2", 110, synRecv(110))
+	pending.add("3", 120, synRecv(120))
+
+	if _, present := pending.match("2"); !present {
+		t.Fatalf("expected flow 2 to be pending")
+	}
+
+	pending.expire(145)
+
+	if _, present := pending.match("1"); present {
+		t.Errorf("expected flow 1 to have expired")
+	}
+	if _, present := pending.match("3"); !present {
+		t.Errorf("expected flow 3 to still be pending")
+	}
+
+	if got := testutil.ToFloat64(promMetrics.HandshakesEvicted.WithLabelValues("expired")); got != 1 {
+		t.Errorf("expected 1 expired handshake, got %v", got)
+	}
+	if got := testutil.ToFloat64(promMetrics.HandshakesMatched); got != 2 {
+		t.Errorf("expected 2 matched handshakes, got %v", got)
+	}
+	if got := testutil.ToFloat64(promMetrics.HandshakesPending); got != 0 {
+		t.Errorf("expected 0 pending handshakes, got %v", got)
+	}
+}
+
+func TestHandshakesOverflow(t *testing.T) {
+	promMetrics := exporter.BuildPromMetrics(prometheus.NewRegistry())
+	pending := newHandshakes(30, 2, promMetrics)
+
+	pending.add("1", 100, synRecv(100))
+	pending.add("2", 101, synRecv(101))
+	pending.add("3", 102, synRecv(102))
+
+	if _, present := pending.match("1"); present {
+		t.Errorf("expected oldest flow to be dropped on overflow")
+	}
+	if got := testutil.ToFloat64(promMetrics.HandshakesEvicted.WithLabelValues("overflow")); got != 1 {
+		t.Errorf("expected 1 overflow eviction, got %v", got)
+	}
+	if got := testutil.ToFloat64(promMetrics.HandshakesPending); got != 2 {
+		t.Errorf("expected 2 pending handshakes, got %v", got)
+	}
+}
+
+func TestHandshakesRetransmit(t *testing.T) {
+	promMetrics := exporter.BuildPromMetrics(prometheus.NewRegistry())
+	pending := newHandshakes(30, 100, promMetrics)
+
+	// a retransmitted SYN_RECV refreshes the entry so the first one must not expire it
+	pending.add("1", 100, synRecv(100))
+	pending.add("1", 120, synRecv(120))
+
+	pending.expire(140)
+
+	if _, present := pending.match("1"); !present {
+		t.Errorf("expected retransmitted flow to still be pending")
+	}
+	if got := testutil.ToFloat64(promMetrics.HandshakesEvicted.WithLabelValues("expired")); got != 0 {
+		t.Errorf("expected no expired handshakes, got %v", got)
+	}
+}
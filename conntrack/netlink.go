@@ -0,0 +1,302 @@
+//go:build linux
+// +build linux
+
+package conntrack
+
+import (
+	"conntrack-lanrtt-analysis/exporter"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// ctnetlink constants from linux/netfilter/nfnetlink.h and nfnetlink_conntrack.h
+const (
+	nfnlSubsysCtnetlink    = 1
+	ipctnlMsgCtNew         = 0
+	nfnlgrpConntrackUpdate = 2
+	nfgenmsgLen            = 4
+
+	nlaTypeMask = 0x3fff
+
+	ctaTupleOrig  = 1
+	ctaTupleReply = 2
+	ctaProtoinfo  = 4
+	ctaID         = 12
+
+	ctaTupleIP    = 1
+	ctaTupleProto = 2
+
+	ctaIPv4Src = 1
+	ctaIPv4Dst = 2
+	ctaIPv6Src = 3
+	ctaIPv6Dst = 4
+
+	ctaProtoNum     = 1
+	ctaProtoSrcPort = 2
+	ctaProtoDstPort = 3
+
+	ctaProtoinfoTCP      = 1
+	ctaProtoinfoTCPState = 1
+)
+
+var tcpStates = []string{"NONE", "SYN_SENT", "SYN_RECV", "ESTABLISHED", "FIN_WAIT", "CLOSE_WAIT", "LAST_ACK", "TIME_WAIT", "CLOSE", "SYN_SENT2"}
+
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// netlinkSource subscribes to conntrack update events directly over a NETLINK_NETFILTER socket
+type netlinkSource struct {
+	networks    []*net.IPNet
+	bufferSize  int
+	debug       bool
+	promMetrics *exporter.PromMetrics
+}
+
+type tuple struct {
+	src   string
+	dst   string
+	sport string
+	dport string
+	proto uint8
+}
+
+func newNetlinkSource(networks []*net.IPNet, bufferSize int, debug bool, promMetrics *exporter.PromMetrics) *netlinkSource {
+	return &netlinkSource{networks: networks, bufferSize: bufferSize, debug: debug, promMetrics: promMetrics}
+}
+
+func (s *netlinkSource) Run(ctx context.Context, handler func(event) error, started func()) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_NETFILTER)
+	if err != nil {
+		return fmt.Errorf("netlink socket error: %v", err)
+	}
+	defer syscall.Close(fd)
+
+	// SO_RCVBUFFORCE needs CAP_NET_ADMIN, fall back to the capped SO_RCVBUF like conntrack does
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUFFORCE, s.bufferSize); err != nil {
+		syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, s.bufferSize)
+	}
+
+	// wake up periodically so a cancelled context is noticed
+	timeout := syscall.Timeval{Sec: 1}
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		return fmt.Errorf("netlink socket timeout error: %v", err)
+	}
+
+	addr := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: 1 << (nfnlgrpConntrackUpdate - 1)}
+	if err := syscall.Bind(fd, addr); err != nil {
+		return fmt.Errorf("netlink bind error: %v", err)
+	}
+	started()
+
+	buf := make([]byte, 65536)
+
+	for ctx.Err() == nil {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			switch err {
+			case syscall.EAGAIN, syscall.EINTR:
+				continue
+			case syscall.ENOBUFS:
+				s.promMetrics.SourceOverruns.Inc()
+				fmt.Printf("netlink receive buffer overrun, conntrack events lost, consider raising netlinkbuffer\n")
+				continue
+			default:
+				return fmt.Errorf("netlink receive error: %v", err)
+			}
+		}
+
+		now := time.Now()
+		timestamp := float64(now.Unix()) + float64(now.Nanosecond()/1000)/1e6
+
+		messages, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			if s.debug {
+				fmt.Printf("error parsing netlink message: %v\n", err)
+			}
+			continue
+		}
+
+		for _, message := range messages {
+			s.handleMessage(message, timestamp, handler)
+		}
+	}
+
+	return nil
+}
+
+func (s *netlinkSource) handleMessage(message syscall.NetlinkMessage, timestamp float64, handler func(event) error) {
+	newEvent, ok, err := decodeNetlinkMessage(message, timestamp)
+	if err != nil {
+		if s.debug {
+			fmt.Printf("error decoding netlink message: %v\n", err)
+		}
+		return
+	}
+
+	if !ok || !networksContain(s.networks, newEvent.OriginalSrc) {
+		return
+	}
+
+	if err := handler(newEvent); err != nil && s.debug {
+		fmt.Printf("error handling netlink event: %v\n", err)
+	}
+}
+
+// decodeNetlinkMessage turns a ctnetlink update into an event, ok is false for messages that are not tcp conntrack updates.
+// Like the conntrack source's regex only SYN_RECV and ESTABLISHED updates are events, teardown states are dropped here
+func decodeNetlinkMessage(message syscall.NetlinkMessage, timestamp float64) (event, bool, error) {
+	if message.Header.Type != nfnlSubsysCtnetlink<<8|ipctnlMsgCtNew {
+		return event{}, false, nil
+	}
+
+	if len(message.Data) < nfgenmsgLen {
+		return event{}, false, errors.New("netlink message too short")
+	}
+
+	attrs, err := parseAttributes(message.Data[nfgenmsgLen:])
+	if err != nil {
+		return event{}, false, err
+	}
+
+	orig, err := decodeTuple(attrs[ctaTupleOrig])
+	if err != nil {
+		return event{}, false, err
+	}
+
+	reply, err := decodeTuple(attrs[ctaTupleReply])
+	if err != nil {
+		return event{}, false, err
+	}
+
+	if orig.proto != syscall.IPPROTO_TCP {
+		return event{}, false, nil
+	}
+
+	state, err := decodeTCPState(attrs[ctaProtoinfo])
+	if err != nil {
+		return event{}, false, err
+	}
+	if state != "SYN_RECV" && state != "ESTABLISHED" {
+		return event{}, false, nil
+	}
+
+	id, present := attrs[ctaID]
+	if !present || len(id) < 4 {
+		return event{}, false, errors.New("netlink message missing conntrack id")
+	}
+
+	newEvent := event{
+		TimeStamp:       timestamp,
+		PacketType:      state,
+		OriginalSrc:     orig.src,
+		OriginalDst:     orig.dst,
+		OriginalSrcPort: orig.sport,
+		OriginalDstPort: orig.dport,
+		ReplySrc:        reply.src,
+		ReplyDst:        reply.dst,
+		ReplySrcPort:    reply.sport,
+		ReplyDstPort:    reply.dport,
+		FlowID:          strconv.FormatUint(uint64(binary.BigEndian.Uint32(id)), 10),
+	}
+
+	return newEvent, true, nil
+}
+
+func decodeTuple(data []byte) (tuple, error) {
+	var t tuple
+
+	attrs, err := parseAttributes(data)
+	if err != nil {
+		return t, err
+	}
+
+	ipAttrs, err := parseAttributes(attrs[ctaTupleIP])
+	if err != nil {
+		return t, err
+	}
+
+	if src, present := ipAttrs[ctaIPv4Src]; present {
+		t.src = net.IP(src).String()
+		t.dst = net.IP(ipAttrs[ctaIPv4Dst]).String()
+	} else if src, present := ipAttrs[ctaIPv6Src]; present {
+		t.src = net.IP(src).String()
+		t.dst = net.IP(ipAttrs[ctaIPv6Dst]).String()
+	} else {
+		return t, errors.New("netlink tuple missing addresses")
+	}
+
+	protoAttrs, err := parseAttributes(attrs[ctaTupleProto])
+	if err != nil {
+		return t, err
+	}
+
+	if num := protoAttrs[ctaProtoNum]; len(num) > 0 {
+		t.proto = num[0]
+	}
+	if port := protoAttrs[ctaProtoSrcPort]; len(port) >= 2 {
+		t.sport = strconv.Itoa(int(binary.BigEndian.Uint16(port)))
+	}
+	if port := protoAttrs[ctaProtoDstPort]; len(port) >= 2 {
+		t.dport = strconv.Itoa(int(binary.BigEndian.Uint16(port)))
+	}
+
+	return t, nil
+}
+
+func decodeTCPState(data []byte) (string, error) {
+	attrs, err := parseAttributes(data)
+	if err != nil {
+		return "", err
+	}
+
+	tcpAttrs, err := parseAttributes(attrs[ctaProtoinfoTCP])
+	if err != nil {
+		return "", err
+	}
+
+	state := tcpAttrs[ctaProtoinfoTCPState]
+	if len(state) < 1 {
+		return "", errors.New("netlink message missing tcp state")
+	}
+	if int(state[0]) >= len(tcpStates) {
+		return "", errors.New("unknown tcp state: " + strconv.Itoa(int(state[0])))
+	}
+
+	return tcpStates[state[0]], nil
+}
+
+// parseAttributes splits a block of netlink attributes into a map keyed by attribute type
+func parseAttributes(data []byte) (map[uint16][]byte, error) {
+	attrs := make(map[uint16][]byte)
+
+	for len(data) >= syscall.SizeofNlAttr {
+		length := int(nativeEndian.Uint16(data[0:2]))
+		attrType := nativeEndian.Uint16(data[2:4]) & nlaTypeMask
+
+		if length < syscall.SizeofNlAttr || length > len(data) {
+			return nil, errors.New("invalid netlink attribute length")
+		}
+
+		attrs[attrType] = data[syscall.SizeofNlAttr:length]
+
+		aligned := (length + syscall.NLA_ALIGNTO - 1) &^ (syscall.NLA_ALIGNTO - 1)
+		if aligned > len(data) {
+			break
+		}
+		data = data[aligned:]
+	}
+
+	return attrs, nil
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package conntrack
+
+import (
+	"conntrack-lanrtt-analysis/exporter"
+	"context"
+	"errors"
+	"net"
+)
+
+type netlinkSource struct{}
+
+func newNetlinkSource(networks []*net.IPNet, bufferSize int, debug bool, promMetrics *exporter.PromMetrics) *netlinkSource {
+	return &netlinkSource{}
+}
+
+func (s *netlinkSource) Run(ctx context.Context, handler func(event) error, started func()) error {
+	return errors.New("netlink event source is only supported on linux")
+}
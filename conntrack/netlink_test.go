@@ -0,0 +1,224 @@
+//go:build linux
+// +build linux
+
+package conntrack
+
+import (
+	"conntrack-lanrtt-analysis/loader"
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func nlAttr(attrType uint16, data []byte) []byte {
+	length := syscall.SizeofNlAttr + len(data)
+	attr := make([]byte, (length+syscall.NLA_ALIGNTO-1)&^(syscall.NLA_ALIGNTO-1))
+	nativeEndian.PutUint16(attr[0:2], uint16(length))
+	nativeEndian.PutUint16(attr[2:4], attrType)
+	copy(attr[syscall.SizeofNlAttr:], data)
+	return attr
+}
+
+func nlNested(attrType uint16, attrs ...[]byte) []byte {
+	var data []byte
+	for _, attr := range attrs {
+		data = append(data, attr...)
+	}
+	return nlAttr(attrType|syscall.NLA_F_NESTED, data)
+}
+
+func be16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func be32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func cannedTuple(attrType uint16, src, dst string, sport, dport uint16, proto uint8) []byte {
+	return nlNested(attrType,
+		nlNested(ctaTupleIP,
+			nlAttr(ctaIPv4Src, net.ParseIP(src).To4()),
+			nlAttr(ctaIPv4Dst, net.ParseIP(dst).To4())),
+		nlNested(ctaTupleProto,
+			nlAttr(ctaProtoNum, []byte{proto}),
+			nlAttr(ctaProtoSrcPort, be16(sport)),
+			nlAttr(ctaProtoDstPort, be16(dport))))
+}
+
+func cannedTuple6(attrType uint16, src, dst string, sport, dport uint16) []byte {
+	return nlNested(attrType,
+		nlNested(ctaTupleIP,
+			nlAttr(ctaIPv6Src, net.ParseIP(src).To16()),
+			nlAttr(ctaIPv6Dst, net.ParseIP(dst).To16())),
+		nlNested(ctaTupleProto,
+			nlAttr(ctaProtoNum, []byte{syscall.IPPROTO_TCP}),
+			nlAttr(ctaProtoSrcPort, be16(sport)),
+			nlAttr(ctaProtoDstPort, be16(dport))))
+}
+
+func cannedMessage6(state uint8) syscall.NetlinkMessage {
+	data := []byte{syscall.AF_INET6, 0, 0, 0}
+	data = append(data, cannedTuple6(ctaTupleOrig, "2001:db8::10", "2606:4700::1111", 58765, 443)...)
+	data = append(data, cannedTuple6(ctaTupleReply, "2606:4700::1111", "2001:db8::10", 443, 58765)...)
+	data = append(data, nlNested(ctaProtoinfo, nlNested(ctaProtoinfoTCP, nlAttr(ctaProtoinfoTCPState, []byte{state})))...)
+	data = append(data, nlAttr(ctaID, be32(1234))...)
+
+	return syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{Type: nfnlSubsysCtnetlink<<8 | ipctnlMsgCtNew},
+		Data:   data,
+	}
+}
+
+func cannedMessage(state uint8, proto uint8) syscall.NetlinkMessage {
+	data := []byte{syscall.AF_INET, 0, 0, 0}
+	data = append(data, cannedTuple(ctaTupleOrig, "10.152.11.29", "61.170.79.234", 58765, 443, proto)...)
+	data = append(data, cannedTuple(ctaTupleReply, "61.170.79.234", "31.205.218.180", 443, 58765, proto)...)
+	data = append(data, nlNested(ctaProtoinfo, nlNested(ctaProtoinfoTCP, nlAttr(ctaProtoinfoTCPState, []byte{state})))...)
+	data = append(data, nlAttr(ctaID, be32(2857185344))...)
+
+	return syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{Type: nfnlSubsysCtnetlink<<8 | ipctnlMsgCtNew},
+		Data:   data,
+	}
+}
+
+func TestDecodeNetlinkMessage(t *testing.T) {
+	testCases := []struct {
+		name          string
+		message       syscall.NetlinkMessage
+		expectedOk    bool
+		expectedEvent event
+	}{
+		{
+			name:       "SynRecv",
+			message:    cannedMessage(2, syscall.IPPROTO_TCP),
+			expectedOk: true,
+			expectedEvent: event{
+				TimeStamp:       1702972533.997256,
+				PacketType:      "SYN_RECV",
+				OriginalSrc:     "10.152.11.29",
+				OriginalDst:     "61.170.79.234",
+				OriginalSrcPort: "58765",
+				OriginalDstPort: "443",
+				ReplySrc:        "61.170.79.234",
+				ReplyDst:        "31.205.218.180",
+				ReplySrcPort:    "443",
+				ReplyDstPort:    "58765",
+				FlowID:          "2857185344",
+			},
+		},
+		{
+			name:       "Established",
+			message:    cannedMessage(3, syscall.IPPROTO_TCP),
+			expectedOk: true,
+			expectedEvent: event{
+				TimeStamp:       1702972533.997256,
+				PacketType:      "ESTABLISHED",
+				OriginalSrc:     "10.152.11.29",
+				OriginalDst:     "61.170.79.234",
+				OriginalSrcPort: "58765",
+				OriginalDstPort: "443",
+				ReplySrc:        "61.170.79.234",
+				ReplyDst:        "31.205.218.180",
+				ReplySrcPort:    "443",
+				ReplyDstPort:    "58765",
+				FlowID:          "2857185344",
+			},
+		},
+		{
+			name:       "SynRecvIPv6",
+			message:    cannedMessage6(2),
+			expectedOk: true,
+			expectedEvent: event{
+				TimeStamp:       1702972533.997256,
+				PacketType:      "SYN_RECV",
+				OriginalSrc:     "2001:db8::10",
+				OriginalDst:     "2606:4700::1111",
+				OriginalSrcPort: "58765",
+				OriginalDstPort: "443",
+				ReplySrc:        "2606:4700::1111",
+				ReplyDst:        "2001:db8::10",
+				ReplySrcPort:    "443",
+				ReplyDstPort:    "58765",
+				FlowID:          "1234",
+			},
+		},
+		{
+			name:       "FinWait",
+			message:    cannedMessage(4, syscall.IPPROTO_TCP),
+			expectedOk: false,
+		},
+		{
+			name:       "NotTCP",
+			message:    cannedMessage(0, syscall.IPPROTO_UDP),
+			expectedOk: false,
+		},
+		{
+			name: "NotConntrack",
+			message: syscall.NetlinkMessage{
+				Header: syscall.NlMsghdr{Type: syscall.NLMSG_DONE},
+			},
+			expectedOk: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			newEvent, ok, err := decodeNetlinkMessage(tc.message, 1702972533.997256)
+			if err != nil {
+				t.Fatalf("Test %s: unexpected error %v", tc.name, err)
+			}
+			if ok != tc.expectedOk {
+				t.Fatalf("Test %s: expected ok %v, got %v", tc.name, tc.expectedOk, ok)
+			}
+			if newEvent != tc.expectedEvent {
+				t.Errorf("Test %s: expected event %+v, got %+v", tc.name, tc.expectedEvent, newEvent)
+			}
+		})
+	}
+}
+
+func TestDecodeNetlinkMessageTruncated(t *testing.T) {
+	message := cannedMessage(2, syscall.IPPROTO_TCP)
+	message.Data = message.Data[:len(message.Data)-6]
+
+	_, _, err := decodeNetlinkMessage(message, 0)
+	if err == nil {
+		t.Errorf("expected error decoding truncated message")
+	}
+}
+
+func TestNetlinkSourceFilter(t *testing.T) {
+	networks, err := loader.ParseNetworks("10.152.0.0", "255.255.240.0")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	var handled []event
+	handler := func(newEvent event) error {
+		handled = append(handled, newEvent)
+		return nil
+	}
+
+	inside := newNetlinkSource(networks, 1064960, false, nil)
+	inside.handleMessage(cannedMessage(2, syscall.IPPROTO_TCP), 0, handler)
+
+	outsideNetworks, _ := loader.ParseNetworks("192.168.0.0/24,2001:db8::/56", "")
+	outside := newNetlinkSource(outsideNetworks, 1064960, false, nil)
+	outside.handleMessage(cannedMessage(2, syscall.IPPROTO_TCP), 0, handler)
+
+	dualStack, _ := loader.ParseNetworks("10.152.0.0/20,2001:db8::/56", "")
+	both := newNetlinkSource(dualStack, 1064960, false, nil)
+	both.handleMessage(cannedMessage(2, syscall.IPPROTO_TCP), 0, handler)
+	both.handleMessage(cannedMessage6(2), 0, handler)
+
+	if len(handled) != 3 {
+		t.Errorf("expected 3 events to pass the filter, got %d", len(handled))
+	}
+}
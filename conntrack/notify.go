@@ -0,0 +1,78 @@
+package conntrack
+
+import (
+	"conntrack-lanrtt-analysis/loader"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const statusInterval = 10 * time.Second
+
+// serviceNotifier keeps systemd informed: READY=1 once the first event source is up, STATUS= with
+// the flow rate and pending handshakes, and WATCHDOG=1 only while events are still being processed
+// so a stalled conntrack stream gets the service restarted
+type serviceNotifier struct {
+	capture  *capture
+	ready    sync.Once
+	interval time.Duration
+	watchdog bool
+}
+
+func newServiceNotifier(capture *capture) *serviceNotifier {
+	notifier := &serviceNotifier{capture: capture, interval: statusInterval}
+
+	// ping at half the watchdog timeout like sd_watchdog_enabled suggests
+	if watchdog := loader.WatchdogInterval(); watchdog > 0 {
+		notifier.watchdog = true
+		if watchdog/2 < notifier.interval {
+			notifier.interval = watchdog / 2
+		}
+	}
+	return notifier
+}
+
+// sourceStarted is passed to every event source, only the first one started signals readiness
+func (n *serviceNotifier) sourceStarted() {
+	n.ready.Do(func() {
+		n.notify("READY=1")
+	})
+}
+
+func (n *serviceNotifier) run(ctx context.Context) {
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+
+	lastEvents := atomic.LoadUint64(&n.capture.events)
+	lastFlows := atomic.LoadUint64(&n.capture.eventMap.matched)
+	last := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			n.notify("STOPPING=1")
+			return
+		case now := <-ticker.C:
+			events := atomic.LoadUint64(&n.capture.events)
+			flows := atomic.LoadUint64(&n.capture.eventMap.matched)
+			pending := atomic.LoadInt64(&n.capture.eventMap.pending)
+
+			rate := float64(flows-lastFlows) / now.Sub(last).Seconds()
+			n.notify(fmt.Sprintf("STATUS=%.1f flows/sec, %d pending handshakes", rate, pending))
+
+			if n.watchdog && events != lastEvents {
+				n.notify("WATCHDOG=1")
+			}
+
+			lastEvents, lastFlows, last = events, flows, now
+		}
+	}
+}
+
+func (n *serviceNotifier) notify(state string) {
+	if err := loader.Notify(state); err != nil {
+		fmt.Printf("error notifying service manager: %v\n", err)
+	}
+}
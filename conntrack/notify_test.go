@@ -0,0 +1,89 @@
+package conntrack
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func listenNotify(t *testing.T) *net.UnixConn {
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	os.Setenv("NOTIFY_SOCKET", socket)
+	return conn
+}
+
+// readNotifications collects everything sent to conn for the given time
+func readNotifications(conn *net.UnixConn, wait time.Duration) []string {
+	var states []string
+	buf := make([]byte, 256)
+	deadline := time.Now().Add(wait)
+	for {
+		conn.SetReadDeadline(deadline)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return states
+		}
+		states = append(states, string(buf[:n]))
+	}
+}
+
+func TestServiceNotifier(t *testing.T) {
+	conn := listenNotify(t)
+	defer conn.Close()
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	c := newTestCapture(t, 10)
+	notifier := newServiceNotifier(c)
+	notifier.interval = 20 * time.Millisecond
+	notifier.watchdog = true
+
+	// a restarted source must not signal readiness again
+	notifier.sourceStarted()
+	notifier.sourceStarted()
+	if states := readNotifications(conn, 50*time.Millisecond); len(states) != 1 || states[0] != "READY=1" {
+		t.Fatalf("Expected a single READY=1, got %v", states)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		notifier.run(ctx)
+		close(done)
+	}()
+
+	// no events, no watchdog pings
+	for _, state := range readNotifications(conn, 100*time.Millisecond) {
+		if state == "WATCHDOG=1" {
+			t.Errorf("Expected no watchdog ping without events")
+		}
+	}
+
+	c.handle(event{TimeStamp: 1, PacketType: "SYN_RECV", OriginalSrc: "192.168.0.10", FlowID: "1"})
+	states := readNotifications(conn, 100*time.Millisecond)
+
+	var pinged, status bool
+	for _, state := range states {
+		pinged = pinged || state == "WATCHDOG=1"
+		status = status || strings.HasPrefix(state, "STATUS=")
+	}
+	if !pinged {
+		t.Errorf("Expected a watchdog ping after an event, got %v", states)
+	}
+	if !status {
+		t.Errorf("Expected a status update, got %v", states)
+	}
+
+	cancel()
+	<-done
+	if states := readNotifications(conn, 50*time.Millisecond); len(states) == 0 || states[len(states)-1] != "STOPPING=1" {
+		t.Errorf("Expected STOPPING=1 on shutdown, got %v", states)
+	}
+}
@@ -5,54 +5,42 @@ import (
 	"conntrack-lanrtt-analysis/loader"
 	"context"
 	"fmt"
-	"os/exec"
-	"strings"
+	"os"
 	"time"
 )
 
-func Poller(arguments *loader.Args, promMetrics *exporter.PromMetrics) {
-
-	args := "-E -e UPDATES -o timestamp,id --buffer-size 1064960 -p tcp --orig-src " + arguments.Network + " --mask-src " + arguments.Subnet
-
-	var ctx context.Context
-	var cancel context.CancelFunc
-	var cmd *exec.Cmd
+// Poller captures events until ctx is cancelled or, when not running continuously, the polling time is up.
+// Each signal on reloads re-reads the config file and applies it without losing the captured flows
+func Poller(ctx context.Context, arguments *loader.Args, promMetrics *exporter.PromMetrics, reloads <-chan os.Signal) error {
 
 	if !arguments.RunContinuous {
 		fmt.Printf("Running for %v..\n", arguments.PollTime)
-		ctx, cancel = context.WithTimeout(context.Background(), time.Duration(arguments.PollTime)*time.Second)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(arguments.PollTime)*time.Second)
 		defer cancel()
-		cmd = exec.CommandContext(ctx, "conntrack", strings.Split(args, " ")...)
 	} else if arguments.RunContinuous {
 		fmt.Printf("Running continuosly..\n")
-		cmd = exec.Command("conntrack", strings.Split(args, " ")...)
-
 	}
 
-	stdout, err := cmd.StdoutPipe()
+	capture, err := newCapture(arguments, promMetrics)
 	if err != nil {
-		fmt.Printf("std out error: %v\n", err)
-		loader.CleanUp(arguments.PidFile)
+		return fmt.Errorf("event source error: %v", err)
 	}
 
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		fmt.Printf("stderr error: %v\n", err)
-		loader.CleanUp(arguments.PidFile)
-	}
-
-	if err := cmd.Start(); err != nil {
-		fmt.Printf("start error: %v\n", err)
-		loader.CleanUp(arguments.PidFile)
+	stopStats := capture.startStats()
+	defer stopStats()
 
+	notifier := newServiceNotifier(capture)
+	if loader.NotifyEnabled() {
+		notifyCtx, stopNotify := context.WithCancel(ctx)
+		defer stopNotify()
+		go notifier.run(notifyCtx)
 	}
 
-	EventParser(stdout, stderr, arguments, promMetrics)
-
-	if err := cmd.Wait(); err != nil {
-		fmt.Printf("wait error: %v\n", err)
-		loader.CleanUp(arguments.PidFile)
+	if err := newSupervisor(arguments, capture, notifier, reloads).run(ctx); err != nil {
+		return err
 	}
 	fmt.Printf("Polling finished\n")
 
+	return nil
 }
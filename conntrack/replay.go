@@ -0,0 +1,96 @@
+package conntrack
+
+import (
+	"bufio"
+	"conntrack-lanrtt-analysis/exporter"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+)
+
+// replaySource feeds a captured `conntrack -E -o timestamp,id` log back through the parser, pacing events by their original timestamps
+type replaySource struct {
+	path  string
+	speed float64
+	hold  bool
+	regex *regexp.Regexp
+	debug bool
+
+	promMetrics *exporter.PromMetrics
+}
+
+func newReplaySource(path string, speed float64, hold bool, debug bool, promMetrics *exporter.PromMetrics) *replaySource {
+	return &replaySource{
+		path:  path,
+		speed: speed,
+		hold:  hold,
+		regex: compileEventRegex(),
+		debug: debug,
+
+		promMetrics: promMetrics,
+	}
+}
+
+func (s *replaySource) Run(ctx context.Context, handler func(event) error, started func()) error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	started()
+
+	var start time.Time
+	var firstTimestamp float64
+
+	// a speed of 0 or less replays as fast as the parser can go
+	paced := func(newEvent event) error {
+		if s.speed > 0 {
+			if start.IsZero() {
+				start = time.Now()
+				firstTimestamp = newEvent.TimeStamp
+			}
+			offset := time.Duration((newEvent.TimeStamp - firstTimestamp) / s.speed * float64(time.Second))
+			if err := sleepUntil(ctx, start.Add(offset)); err != nil {
+				return err
+			}
+		}
+		return handler(newEvent)
+	}
+
+	scanner := bufio.NewScanner(file)
+	for ctx.Err() == nil && scanner.Scan() {
+		processLine(scanner.Text(), s.regex, paced, s.debug, s.promMetrics)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Replay of %s finished\n", s.path)
+
+	// keep serving the replayed state until stopped when running continuously
+	if s.hold {
+		<-ctx.Done()
+	}
+
+	return nil
+}
+
+func sleepUntil(ctx context.Context, deadline time.Time) error {
+	wait := time.Until(deadline)
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,82 @@
+package conntrack
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const replayCapture = `[1702972533.340676]	 [UPDATE] tcp      6 60 SYN_RECV src=10.152.4.231 dst=173.222.210.216 sport=51679 dport=443 src=173.222.210.216 dst=31.205.218.167 sport=443 dport=51679 id=2858042624
+[1702972533.785766]	 [UPDATE] tcp      6 120 FIN_WAIT src=10.152.10.141 dst=104.91.71.86 sport=62689 dport=443 src=104.91.71.86 dst=31.205.218.184 sport=443 dport=62689 [ASSURED] id=3451258432
+[1702972533.840676]	 [UPDATE] tcp      6 432000 ESTABLISHED src=10.152.4.231 dst=173.222.210.216 sport=51679 dport=443 src=173.222.210.216 dst=31.205.218.167 sport=443 dport=51679 [ASSURED] id=2858042624
+`
+
+func writeCapture(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "capture.log")
+	if err := os.WriteFile(path, []byte(replayCapture), 0644); err != nil {
+		t.Fatalf("unable to write capture: %v", err)
+	}
+	return path
+}
+
+func TestReplaySource(t *testing.T) {
+	testCases := []struct {
+		name       string
+		speed      float64
+		minElapsed time.Duration
+	}{
+		{
+			name:       "AsFastAsPossible",
+			speed:      0,
+			minElapsed: 0,
+		},
+		{
+			name:       "Accelerated",
+			speed:      10,
+			minElapsed: 50 * time.Millisecond,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			source := newReplaySource(writeCapture(t), tc.speed, false, false, nil)
+
+			var events []event
+			handler := func(newEvent event) error {
+				events = append(events, newEvent)
+				return nil
+			}
+
+			start := time.Now()
+			if err := source.Run(context.Background(), handler, func() {}); err != nil {
+				t.Fatalf("Test %s: unexpected error %v", tc.name, err)
+			}
+			elapsed := time.Since(start)
+
+			if len(events) != 2 {
+				t.Fatalf("Test %s: expected 2 events, got %d", tc.name, len(events))
+			}
+			if events[0].PacketType != "SYN_RECV" || events[1].PacketType != "ESTABLISHED" {
+				t.Errorf("Test %s: unexpected event order %v, %v", tc.name, events[0].PacketType, events[1].PacketType)
+			}
+			if elapsed < tc.minElapsed {
+				t.Errorf("Test %s: replay took %v, expected at least %v", tc.name, elapsed, tc.minElapsed)
+			}
+		})
+	}
+}
+
+func TestReplaySourceCancelled(t *testing.T) {
+	source := newReplaySource(writeCapture(t), 0.001, true, false, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	handler := func(newEvent event) error { return nil }
+
+	if err := source.Run(ctx, handler, func() {}); err != nil {
+		t.Errorf("expected cancelled replay to stop cleanly, got %v", err)
+	}
+}
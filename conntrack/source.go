@@ -0,0 +1,188 @@
+package conntrack
+
+import (
+	"conntrack-lanrtt-analysis/exporter"
+	"conntrack-lanrtt-analysis/loader"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// EventSource delivers conntrack events to handler until ctx is done or the source is exhausted.
+// started is called once the source is set up and receiving events
+type EventSource interface {
+	Run(ctx context.Context, handler func(event) error, started func()) error
+}
+
+func NewEventSource(arguments *loader.Args, promMetrics *exporter.PromMetrics) (EventSource, error) {
+	switch arguments.Source {
+	case "conntrack", "":
+		networks, err := sourceNetworks(arguments)
+		if err != nil {
+			return nil, err
+		}
+		return newProcessSource(networks, len(arguments.Subnets) > 0, arguments.NetlinkBuffer, arguments.Debug, promMetrics), nil
+	case "netlink":
+		networks, err := sourceNetworks(arguments)
+		if err != nil {
+			return nil, err
+		}
+		return newNetlinkSource(networks, arguments.NetlinkBuffer, arguments.Debug, promMetrics), nil
+	case "replay":
+		if arguments.ReplayFile == "" {
+			return nil, errors.New("replay source needs a replay file")
+		}
+		return newReplaySource(arguments.ReplayFile, arguments.ReplaySpeed, arguments.RunContinuous, arguments.Debug, promMetrics), nil
+	default:
+		return nil, errors.New("unknown event source: " + arguments.Source)
+	}
+}
+
+// sourceNetworks returns the named subnets when configured, otherwise the -network list
+func sourceNetworks(arguments *loader.Args) ([]*net.IPNet, error) {
+	if len(arguments.Subnets) == 0 {
+		return loader.ParseNetworks(arguments.Network, arguments.Subnet)
+	}
+
+	subnets, err := loader.ParseSubnets(arguments.Subnets)
+	if err != nil {
+		return nil, err
+	}
+
+	networks := make([]*net.IPNet, 0, len(subnets))
+	for _, subnet := range subnets {
+		networks = append(networks, subnet.Network)
+	}
+	return networks, nil
+}
+
+// processSource runs conntrack -E and parses its text output. Each network gets its own conntrack
+// filtered by the kernel, or with singleStream one unfiltered conntrack per family is filtered in-process
+type processSource struct {
+	commands    [][]string
+	filter      []*net.IPNet
+	regex       *regexp.Regexp
+	debug       bool
+	promMetrics *exporter.PromMetrics
+}
+
+func newProcessSource(networks []*net.IPNet, singleStream bool, bufferSize int, debug bool, promMetrics *exporter.PromMetrics) *processSource {
+	source := &processSource{
+		commands:    make([][]string, 0, len(networks)),
+		regex:       compileEventRegex(),
+		debug:       debug,
+		promMetrics: promMetrics,
+	}
+	buffer := strconv.Itoa(bufferSize)
+
+	if !singleStream {
+		for _, network := range networks {
+			args := "-E -e UPDATES -o timestamp,id --buffer-size " + buffer + " -f " + networkFamily(network) + " -p tcp --orig-src " + network.IP.String() + " --mask-src " + net.IP(network.Mask).String()
+			source.commands = append(source.commands, strings.Split(args, " "))
+		}
+		return source
+	}
+
+	families := make(map[string]bool)
+	for _, network := range networks {
+		family := networkFamily(network)
+		if !families[family] {
+			families[family] = true
+			args := "-E -e UPDATES -o timestamp,id --buffer-size " + buffer + " -f " + family + " -p tcp"
+			source.commands = append(source.commands, strings.Split(args, " "))
+		}
+	}
+	source.filter = networks
+
+	return source
+}
+
+func (s *processSource) Run(ctx context.Context, handler func(event) error, started func()) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// events from parallel conntrack processes are handed to the matcher one at a time
+	handlerMux := &sync.Mutex{}
+	serialised := func(newEvent event) error {
+		if s.filter != nil && !networksContain(s.filter, newEvent.OriginalSrc) {
+			return nil
+		}
+
+		handlerMux.Lock()
+		defer handlerMux.Unlock()
+		return handler(newEvent)
+	}
+
+	// the source is up once every conntrack process has started
+	remaining := int32(len(s.commands))
+	commandStarted := func() {
+		if atomic.AddInt32(&remaining, -1) == 0 {
+			started()
+		}
+	}
+
+	errs := make(chan error, len(s.commands))
+	for _, args := range s.commands {
+		go func(args []string) {
+			err := s.runCommand(ctx, args, serialised, commandStarted)
+			// one process failing stops the others so the error is reported
+			if err != nil {
+				cancel()
+			}
+			errs <- err
+		}(args)
+	}
+
+	var firstErr error
+	for range s.commands {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (s *processSource) runCommand(ctx context.Context, args []string, handler func(event) error, started func()) error {
+	cmd := exec.CommandContext(ctx, "conntrack", args...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("std out error: %v", err)
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("stderr error: %v", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start error: %v", err)
+	}
+	started()
+
+	go processStderr(stderr, s.promMetrics)
+	processStdout(stdout, s.regex, handler, s.debug, s.promMetrics)
+
+	if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("wait error: %v", err)
+	}
+	return nil
+}
+
+func networkFamily(network *net.IPNet) string {
+	if network.IP.To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+func networksContain(networks []*net.IPNet, address string) bool {
+	return anyContains(networks, net.ParseIP(address))
+}
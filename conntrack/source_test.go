@@ -0,0 +1,56 @@
+package conntrack
+
+import (
+	"conntrack-lanrtt-analysis/loader"
+	"strings"
+	"testing"
+)
+
+func TestProcessSourceCommands(t *testing.T) {
+	networks, err := loader.ParseNetworks("192.168.0.0/24,2001:db8::/56", "")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	source := newProcessSource(networks, false, 1064960, false, nil)
+
+	expected := []string{
+		"-E -e UPDATES -o timestamp,id --buffer-size 1064960 -f ipv4 -p tcp --orig-src 192.168.0.0 --mask-src 255.255.255.0",
+		"-E -e UPDATES -o timestamp,id --buffer-size 1064960 -f ipv6 -p tcp --orig-src 2001:db8:: --mask-src ffff:ffff:ffff:ff00::",
+	}
+
+	if len(source.commands) != len(expected) {
+		t.Fatalf("expected %d conntrack commands, got %d", len(expected), len(source.commands))
+	}
+	for i, args := range source.commands {
+		if strings.Join(args, " ") != expected[i] {
+			t.Errorf("expected command %q, got %q", expected[i], strings.Join(args, " "))
+		}
+	}
+}
+
+func TestProcessSourceSingleStream(t *testing.T) {
+	networks, err := loader.ParseNetworks("192.168.10.0/24,192.168.20.0/24,2001:db8::/56", "")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	source := newProcessSource(networks, true, 2129920, false, nil)
+
+	expected := []string{
+		"-E -e UPDATES -o timestamp,id --buffer-size 2129920 -f ipv4 -p tcp",
+		"-E -e UPDATES -o timestamp,id --buffer-size 2129920 -f ipv6 -p tcp",
+	}
+
+	if len(source.commands) != len(expected) {
+		t.Fatalf("expected %d conntrack commands, got %d", len(expected), len(source.commands))
+	}
+	for i, args := range source.commands {
+		if strings.Join(args, " ") != expected[i] {
+			t.Errorf("expected command %q, got %q", expected[i], strings.Join(args, " "))
+		}
+	}
+	if len(source.filter) != 3 {
+		t.Errorf("expected in-process filter over 3 subnets, got %d", len(source.filter))
+	}
+}
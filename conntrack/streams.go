@@ -5,28 +5,194 @@ import (
 	"conntrack-lanrtt-analysis/exporter"
 	"conntrack-lanrtt-analysis/loader"
 	"conntrack-lanrtt-analysis/metrics"
+	"context"
 	"fmt"
 	"io"
+	"reflect"
 	"regexp"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
-func EventParser(stdout io.ReadCloser, stderr io.ReadCloser, arguments *loader.Args, promMetrics *exporter.PromMetrics) {
-	regex := compileEventRegex()
+// capture is the state shared by the event handler and the stats loop. It outlives the event source
+// so the source can be restarted on a config reload without losing flows or pending handshakes
+type capture struct {
+	// events handed to the matcher, read by the service notifier
+	events uint64
 
-	// map of individual unique events i.e each SYN_RECV event and each ESTABLISHED event
-	eventMap := make(map[string]map[string]interface{})
+	arguments   *loader.Args
+	promMetrics *exporter.PromMetrics
+
+	// pending SYN_RECV events waiting for their corresponding ESTABLISHED event
+	eventMap *handshakes
 
 	// map of each device and its flow's RTT values
-	deviceFlows := make(map[string][]float64)
+	deviceFlows map[string][]float64
 
 	// Flow structs for every unique flow (the SYN_RECV and its corresponding ESTABLISHED) during the capture period
-	allFlows := make([]metrics.Flow, 0, 10)
+	allFlows metrics.FlowBuffer
+
+	observer *metrics.FlowObserver
+	filter   *eventFilter
+
+	// mux guards the flows, reloadMux keeps events out while the configuration is swapped
+	mux       *sync.Mutex
+	reloadMux sync.RWMutex
+}
+
+func newCapture(arguments *loader.Args, promMetrics *exporter.PromMetrics) (*capture, error) {
+
+	c := &capture{
+		arguments:   arguments,
+		promMetrics: promMetrics,
+		eventMap:    newHandshakes(float64(arguments.HandshakeTTL), arguments.MaxPending, promMetrics),
+		deviceFlows: make(map[string][]float64),
+		mux:         &sync.Mutex{},
+	}
+
+	// histograms and the sketch are updated once per matched flow, gauges once per stats period
+	sketch, groups, err := newSeries(arguments, promMetrics)
+	if err != nil {
+		return nil, err
+	}
+	c.observer = metrics.NewFlowObserver(sketch, promMetrics, groups...)
+
+	rules, err := loader.ParseRules(arguments.Rules)
+	if err != nil {
+		return nil, err
+	}
+	c.filter = newEventFilter(rules, promMetrics)
+
+	return c, nil
+}
+
+// newSeries builds the streaming quantiles over the last QuantileWindow seconds of flows and the breakdowns
+func newSeries(arguments *loader.Args, promMetrics *exporter.PromMetrics) (*metrics.WindowedSketch, []*metrics.GroupedSeries, error) {
+
+	sketch := metrics.NewWindowedSketch(float64(arguments.QuantileWin))
 
-	mux := &sync.Mutex{}
+	groups := []*metrics.GroupedSeries{metrics.NewGroupedSeries("Family", metrics.AddressFamily, float64(arguments.QuantileWin), promMetrics.Family)}
+	if len(arguments.Subnets) > 0 {
+		subnets, err := loader.ParseSubnets(arguments.Subnets)
+		if err != nil {
+			return nil, nil, err
+		}
+		groups = append(groups, metrics.NewGroupedSeries("Subnet", metrics.SubnetName(subnets), float64(arguments.QuantileWin), promMetrics.Subnet))
+	}
 
-	go metrics.ParseFlows(&allFlows, deviceFlows, arguments, promMetrics, mux)
-	processStreams(stdout, stderr, regex, eventMap, &allFlows, deviceFlows, arguments, mux)
+	return sketch, groups, nil
+}
+
+func (c *capture) handle(newEvent event) error {
+	atomic.AddUint64(&c.events, 1)
+	c.promMetrics.Readiness.EventSeen()
+	c.promMetrics.Events.WithLabelValues(newEvent.PacketType).Inc()
+
+	c.reloadMux.RLock()
+	defer c.reloadMux.RUnlock()
+
+	return processNewEvent(newEvent, c.eventMap, &c.allFlows, c.deviceFlows, c.observer, c.filter, c.arguments, c.mux)
+}
+
+// startStats runs the stats loop until the returned stop is called, which waits for the final snapshot
+func (c *capture) startStats() func() {
+
+	// the stats loop outlives the source so events still in flight are counted in the final snapshot
+	statsCtx, stopStats := context.WithCancel(context.Background())
+	statsDone := make(chan struct{})
+
+	go func() {
+		metrics.ParseFlows(statsCtx, &c.allFlows, c.deviceFlows, c.observer, c.arguments, c.promMetrics, c.mux)
+		close(statsDone)
+	}()
+
+	return func() {
+		stopStats()
+		<-statsDone
+	}
+}
+
+// reload re-reads the config file and applies it, restart is true when the source needs restarting
+// with the new filter. A rejected config leaves the running configuration untouched
+func (c *capture) reload() (restart bool) {
+
+	newArgs, err := loader.ReloadConfig(c.arguments)
+	if err == nil {
+		restart, err = c.apply(newArgs)
+	}
+
+	if err != nil {
+		fmt.Printf("config reload rejected: %v\n", err)
+		c.promMetrics.ConfigReloads.WithLabelValues("rejected").Inc()
+		return false
+	}
+
+	fmt.Printf("config reloaded from %s\n", newArgs.ConfigFile)
+	c.promMetrics.ConfigReloads.WithLabelValues("success").Inc()
+	return restart
+}
+
+func (c *capture) apply(newArgs *loader.Args) (bool, error) {
+
+	rules, err := loader.ParseRules(newArgs.Rules)
+	if err != nil {
+		return false, err
+	}
+
+	// the sketches only have to start again when the window or the subnets change
+	rebuildSeries := newArgs.QuantileWin != c.arguments.QuantileWin || !reflect.DeepEqual(newArgs.Subnets, c.arguments.Subnets)
+	var sketch *metrics.WindowedSketch
+	var groups []*metrics.GroupedSeries
+	if rebuildSeries {
+		sketch, groups, err = newSeries(newArgs, c.promMetrics)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// series of quantiles no longer configured would otherwise keep their last value
+	quantilesChanged := !reflect.DeepEqual(newArgs.Quantiles, c.arguments.Quantiles)
+
+	restart := sourceChanged(c.arguments, newArgs)
+
+	c.reloadMux.Lock()
+	defer c.reloadMux.Unlock()
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	// keep the newest flows when the buffer shrinks
+	c.allFlows.Shrink(newArgs.BufferSize)
+
+	if rebuildSeries {
+		c.promMetrics.Subnet.Reset()
+		c.observer.Reconfigure(sketch, groups...)
+	}
+	if quantilesChanged {
+		c.promMetrics.QuantileAll.Reset()
+		c.promMetrics.Family.Quantile.Reset()
+		c.promMetrics.Subnet.Quantile.Reset()
+	}
+
+	c.filter = newEventFilter(rules, c.promMetrics)
+	c.eventMap.maxAge = float64(newArgs.HandshakeTTL)
+	c.eventMap.maxPending = newArgs.MaxPending
+
+	// the stats loop and main hold on to the same Args
+	*c.arguments = *newArgs
+
+	return restart, nil
+}
+
+// sourceChanged reports whether the settings the event source was built from differ
+func sourceChanged(current, reloaded *loader.Args) bool {
+	return current.Source != reloaded.Source ||
+		current.Network != reloaded.Network ||
+		current.Subnet != reloaded.Subnet ||
+		!reflect.DeepEqual(current.Subnets, reloaded.Subnets) ||
+		current.NetlinkBuffer != reloaded.NetlinkBuffer ||
+		current.ReplayFile != reloaded.ReplayFile ||
+		current.ReplaySpeed != reloaded.ReplaySpeed
 }
 
 func compileEventRegex() *regexp.Regexp {
@@ -34,29 +200,69 @@ func compileEventRegex() *regexp.Regexp {
 	return regexp.MustCompile(pattern)
 }
 
-func processStreams(stdout, stderr io.ReadCloser, regex *regexp.Regexp, eventMap map[string]map[string]interface{}, allFlows *[]metrics.Flow, deviceFlows map[string][]float64, arguments *loader.Args, mux *sync.Mutex) {
-	go processStdout(stdout, regex, eventMap, allFlows, deviceFlows, arguments, mux)
-	go processStderr(stderr)
-}
-
-func processStdout(stdout io.ReadCloser, regex *regexp.Regexp, eventMap map[string]map[string]interface{}, allFlows *[]metrics.Flow, deviceFlows map[string][]float64, arguments *loader.Args, mux *sync.Mutex) {
+func processStdout(stdout io.ReadCloser, regex *regexp.Regexp, handler func(event) error, debug bool, promMetrics *exporter.PromMetrics) {
 	scanner := bufio.NewScanner(stdout)
 	for scanner.Scan() {
-		output := scanner.Text()
-		err := handleOutput(output, regex, eventMap, allFlows, deviceFlows, arguments, mux)
-		if err != nil {
-			if arguments.Debug {
-				fmt.Printf("error parsing conntrack string: %v\n", err)
-			}
-		}
+		processLine(scanner.Text(), regex, handler, debug, promMetrics)
+	}
+
+}
+
+// processLine handles one line of conntrack output, counting the lines read and those the regex rejects
+func processLine(output string, regex *regexp.Regexp, handler func(event) error, debug bool, promMetrics *exporter.PromMetrics) {
+	if promMetrics != nil {
+		promMetrics.ConntrackLines.Inc()
 	}
 
+	err := handleOutput(output, regex, handler)
+	if err == errNoRegexMatch && promMetrics != nil {
+		promMetrics.UnparsedLines.Inc()
+	}
+	if err != nil && debug {
+		fmt.Printf("error parsing conntrack string: %v: %s\n", err, output)
+	}
 }
 
-func processStderr(stderr io.ReadCloser) {
+// stderrPattern is a known conntrack warning or error, hint says what to do about it
+type stderrPattern struct {
+	match   string
+	overrun bool
+	hint    string
+}
+
+var stderrPatterns = []stderrPattern{
+	{match: "ENOBUFS", overrun: true, hint: "conntrack events lost, RTT statistics are biased until this stops, consider raising netlinkbuffer"},
+	{match: "Operation not permitted", hint: "conntrack needs CAP_NET_ADMIN"},
+	{match: "Can't open handler", hint: "check the nf_conntrack_netlink module is loaded"},
+	{match: "Protocol not supported", hint: "check the nf_conntrack_netlink module is loaded"},
+	{match: "Invalid argument", hint: "check the network and subnet settings"},
+}
+
+func matchStderr(line string) (stderrPattern, bool) {
+	for _, pattern := range stderrPatterns {
+		if strings.Contains(line, pattern.match) {
+			return pattern, true
+		}
+	}
+	return stderrPattern{}, false
+}
+
+// processStderr echoes conntrack's stderr, counting dropped event warnings and explaining known errors
+func processStderr(stderr io.ReadCloser, promMetrics *exporter.PromMetrics) {
 	scanner := bufio.NewScanner(stderr)
 	for scanner.Scan() {
-		fmt.Println(scanner.Text())
+		line := scanner.Text()
+
+		pattern, known := matchStderr(line)
+		if !known {
+			fmt.Println(line)
+			continue
+		}
+
+		if pattern.overrun {
+			promMetrics.SourceOverruns.Inc()
+		}
+		fmt.Printf("%s (%s)\n", line, pattern.hint)
 	}
 
 }
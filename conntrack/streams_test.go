@@ -0,0 +1,156 @@
+package conntrack
+
+import (
+	"conntrack-lanrtt-analysis/exporter"
+	"conntrack-lanrtt-analysis/loader"
+	"conntrack-lanrtt-analysis/metrics"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCaptureReload(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "lan-rtt.json")
+	writeConfig := func(config string) {
+		if err := os.WriteFile(configFile, []byte(config), 0644); err != nil {
+			t.Fatalf("unable to write config: %v", err)
+		}
+	}
+
+	arguments := &loader.Args{ConfigFile: configFile, PromPort: "1986", ReadyWindow: 60, RunContinuous: true, BufferSize: 5, StatsPeriod: 5, HandshakeTTL: 30, MaxPending: 100, QuantileWin: 60}
+	promMetrics := exporter.BuildPromMetrics(prometheus.NewRegistry())
+	c, err := newCapture(arguments, promMetrics)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// start from the file's settings with the defaults filled in
+	writeConfig(`{"network": "192.168.0.0/24", "buffersize": 5, "statsperiod": 5}`)
+	c.reload()
+
+	for i := 1; i <= 5; i++ {
+		c.allFlows.Add(metrics.Flow{LanRTT: float64(i)}, 100)
+	}
+
+	// a smaller buffer keeps the newest flows and the source keeps running
+	writeConfig(`{"network": "192.168.0.0/24", "buffersize": 2, "statsperiod": 10}`)
+	if c.reload() {
+		t.Errorf("Expected no source restart when only the buffer and stats period change")
+	}
+	if flows := c.allFlows.Flows(); len(flows) != 2 || flows[0].LanRTT != 4 || flows[1].LanRTT != 5 {
+		t.Errorf("Expected the newest flows [4 5], got %v", flows)
+	}
+	if arguments.StatsPeriod != 10 {
+		t.Errorf("Expected statsperiod 10, got %v", arguments.StatsPeriod)
+	}
+
+	// a new network needs the source rebuilt
+	writeConfig(`{"network": "10.0.0.0/8", "buffersize": 2, "statsperiod": 10}`)
+	if !c.reload() {
+		t.Errorf("Expected a source restart when the network changes")
+	}
+
+	// an invalid config is rejected and the running settings are kept
+	writeConfig(`{"network": "10.0.0.0/8", "buffersize": 0, "statsperiod": 10}`)
+	if c.reload() {
+		t.Errorf("Expected no source restart for a rejected config")
+	}
+	if arguments.BufferSize != 2 {
+		t.Errorf("Expected buffersize to stay 2, got %v", arguments.BufferSize)
+	}
+
+	if count := testutil.ToFloat64(promMetrics.ConfigReloads.WithLabelValues("success")); count != 3 {
+		t.Errorf("Expected 3 successful reloads, got %v", count)
+	}
+	if count := testutil.ToFloat64(promMetrics.ConfigReloads.WithLabelValues("rejected")); count != 1 {
+		t.Errorf("Expected 1 rejected reload, got %v", count)
+	}
+}
+
+func TestCaptureReloadQuantiles(t *testing.T) {
+	c, writeConfig := newReloadCapture(t)
+	writeConfig(`{"network": "192.168.0.0/24", "quantiles": [0.5, 0.99]}`)
+	c.reload()
+
+	c.promMetrics.QuantileAll.WithLabelValues("0.99").Set(20)
+	c.promMetrics.Family.Quantile.WithLabelValues("ipv4", "0.99").Set(20)
+
+	// the same quantiles keep their series until the next stats period
+	writeConfig(`{"network": "192.168.0.0/24", "quantiles": [0.5, 0.99], "buffersize": 5}`)
+	c.reload()
+	if series := testutil.CollectAndCount(c.promMetrics.QuantileAll); series != 1 {
+		t.Errorf("Expected the quantile series to be kept, got %d series", series)
+	}
+
+	// a dropped quantile must not stay exported at its last value
+	writeConfig(`{"network": "192.168.0.0/24", "quantiles": [0.5]}`)
+	c.reload()
+	if series := testutil.CollectAndCount(c.promMetrics.QuantileAll); series != 0 {
+		t.Errorf("Expected the quantile series to be dropped, got %d series", series)
+	}
+	if series := testutil.CollectAndCount(c.promMetrics.Family.Quantile); series != 0 {
+		t.Errorf("Expected the family quantile series to be dropped, got %d series", series)
+	}
+}
+
+func TestCaptureReloadDeviceMetrics(t *testing.T) {
+	c, writeConfig := newReloadCapture(t)
+	writeConfig(`{"network": "192.168.0.0/24", "devicemetrics": true}`)
+	c.reload()
+
+	metrics.CalculateDeviceStats(map[string][]float64{"192.168.0.10": {5}}, c.arguments, c.promMetrics)
+	if series := testutil.CollectAndCount(c.promMetrics.Devices); series != 2 {
+		t.Fatalf("Expected the device mean and flow count series, got %d series", series)
+	}
+
+	// turning devicemetrics off stops exporting the last period's devices
+	writeConfig(`{"network": "192.168.0.0/24", "devicemetrics": false}`)
+	c.reload()
+	metrics.CalculateDeviceStats(map[string][]float64{"192.168.0.10": {5}}, c.arguments, c.promMetrics)
+	if series := testutil.CollectAndCount(c.promMetrics.Devices); series != 0 {
+		t.Errorf("Expected no device series with devicemetrics off, got %d series", series)
+	}
+}
+
+// newReloadCapture is a test capture reloading from a config file written by writeConfig
+func newReloadCapture(t *testing.T) (c *capture, writeConfig func(config string)) {
+	t.Helper()
+
+	configFile := filepath.Join(t.TempDir(), "lan-rtt.json")
+	writeConfig = func(config string) {
+		if err := os.WriteFile(configFile, []byte(config), 0644); err != nil {
+			t.Fatalf("unable to write config: %v", err)
+		}
+	}
+
+	// the startup-only settings a reload keeps
+	c = newTestCapture(t, 10)
+	c.arguments.ConfigFile = configFile
+	c.arguments.PromPort = "1986"
+	c.arguments.ReadyWindow = 60
+	c.arguments.RunContinuous = true
+
+	return c, writeConfig
+}
+
+func TestProcessStderr(t *testing.T) {
+	stderr := strings.Join([]string{
+		"WARNING: We have hit ENOBUFS! We are losing events.",
+		"This message means that the current netlink socket buffer size is too small.",
+		"Please, check --buffer-size in conntrack(8) manpage.",
+		"WARNING: We have hit ENOBUFS! We are losing events.",
+		"conntrack v1.4.6 (conntrack-tools): Operation not permitted",
+	}, "\n")
+
+	promMetrics := exporter.BuildPromMetrics(prometheus.NewRegistry())
+	processStderr(io.NopCloser(strings.NewReader(stderr)), promMetrics)
+
+	if count := testutil.ToFloat64(promMetrics.SourceOverruns); count != 2 {
+		t.Errorf("Expected 2 overruns, got %v", count)
+	}
+}
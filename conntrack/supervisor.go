@@ -0,0 +1,144 @@
+package conntrack
+
+import (
+	"conntrack-lanrtt-analysis/exporter"
+	"conntrack-lanrtt-analysis/loader"
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// why an event source stopped, the reason label of lanRtt_source_restarts_total
+const (
+	exitReload      = "reload"
+	exitFinished    = "exited"
+	exitFailed      = "failed"
+	exitStartFailed = "start_failed"
+)
+
+const (
+	minRestartBackoff = time.Second
+	maxRestartBackoff = time.Minute
+)
+
+// supervisor keeps the event source running. When running continuously a source that exits is
+// restarted with exponential backoff, and the capture state (flows, pending handshakes, metrics) is kept
+type supervisor struct {
+	arguments  *loader.Args
+	capture    *capture
+	notifier   *serviceNotifier
+	reloads    <-chan os.Signal
+	newSource  func(arguments *loader.Args, promMetrics *exporter.PromMetrics) (EventSource, error)
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+func newSupervisor(arguments *loader.Args, capture *capture, notifier *serviceNotifier, reloads <-chan os.Signal) *supervisor {
+	return &supervisor{
+		arguments:  arguments,
+		capture:    capture,
+		notifier:   notifier,
+		reloads:    reloads,
+		newSource:  NewEventSource,
+		minBackoff: minRestartBackoff,
+		maxBackoff: maxRestartBackoff,
+	}
+}
+
+// run returns once ctx is done, or when not running continuously once the source stops
+func (s *supervisor) run(ctx context.Context) error {
+	backoff := s.minBackoff
+
+	for {
+		source, err := s.newSource(s.arguments, s.capture.promMetrics)
+		if err != nil {
+			return fmt.Errorf("event source error: %v", err)
+		}
+
+		started := time.Now()
+		reason, err := s.runSource(ctx, source)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		// exits are only counted as restarts when the source is started again
+		if reason == exitReload {
+			s.recordExit(reason)
+			fmt.Printf("restarting %s source with the reloaded filter\n", s.arguments.Source)
+			continue
+		}
+
+		if !s.arguments.RunContinuous {
+			if err != nil {
+				return fmt.Errorf("%s source error: %v", s.arguments.Source, err)
+			}
+			return nil
+		}
+		s.recordExit(reason)
+
+		// a source that ran for a while before stopping starts again from the shortest backoff
+		if time.Since(started) >= s.maxBackoff {
+			backoff = s.minBackoff
+		}
+
+		fmt.Printf("%s source stopped (%s: %v), restarting in %v\n", s.arguments.Source, reason, err, backoff)
+		if sleepUntil(ctx, time.Now().Add(backoff)) != nil {
+			return nil
+		}
+
+		backoff *= 2
+		if backoff > s.maxBackoff {
+			backoff = s.maxBackoff
+		}
+	}
+}
+
+// runSource runs source until it stops, applying reloads as they arrive. A reload that changes the
+// settings the source was built from stops it to be rebuilt
+func (s *supervisor) runSource(ctx context.Context, source EventSource) (string, error) {
+
+	sourceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var running int32
+	started := func() {
+		atomic.StoreInt32(&running, 1)
+		s.capture.promMetrics.Readiness.SourceStarted()
+		s.notifier.sourceStarted()
+	}
+	defer s.capture.promMetrics.Readiness.SourceStopped()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- source.Run(sourceCtx, s.capture.handle, started)
+	}()
+
+	for {
+		select {
+		case err := <-done:
+			switch {
+			case err == nil:
+				return exitFinished, nil
+			case atomic.LoadInt32(&running) == 0:
+				return exitStartFailed, err
+			default:
+				return exitFailed, err
+			}
+		case <-s.reloads:
+			if s.capture.reload() && ctx.Err() == nil {
+				cancel()
+				<-done
+				return exitReload, nil
+			}
+		}
+	}
+}
+
+func (s *supervisor) recordExit(reason string) {
+	promMetrics := s.capture.promMetrics
+	promMetrics.SourceRestarts.WithLabelValues(reason).Inc()
+	promMetrics.SourceLastExit.Reset()
+	promMetrics.SourceLastExit.WithLabelValues(reason).Set(1)
+}
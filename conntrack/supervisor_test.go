@@ -0,0 +1,89 @@
+package conntrack
+
+import (
+	"conntrack-lanrtt-analysis/exporter"
+	"conntrack-lanrtt-analysis/loader"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// flakySource fails to start, then dies after one event, then runs until cancelled
+type flakySource struct {
+	attempt int
+}
+
+func (s *flakySource) Run(ctx context.Context, handler func(event) error, started func()) error {
+	s.attempt++
+	switch s.attempt {
+	case 1:
+		return errors.New("start error: conntrack not found")
+	case 2:
+		started()
+		handler(event{TimeStamp: 1, PacketType: "SYN_RECV", OriginalSrc: "192.168.0.10", FlowID: "1"})
+		return errors.New("wait error: exit status 1")
+	default:
+		started()
+		handler(event{TimeStamp: 1.01, PacketType: "ESTABLISHED", OriginalSrc: "192.168.0.10", FlowID: "1"})
+		<-ctx.Done()
+		return nil
+	}
+}
+
+func TestSupervisorRestarts(t *testing.T) {
+	c := newTestCapture(t, 10)
+	c.arguments.RunContinuous = true
+	promMetrics := c.promMetrics
+
+	source := &flakySource{}
+	s := newSupervisor(c.arguments, c, newServiceNotifier(c), nil)
+	s.newSource = func(arguments *loader.Args, promMetrics *exporter.PromMetrics) (EventSource, error) {
+		return source, nil
+	}
+	s.minBackoff = time.Millisecond
+	s.maxBackoff = 4 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	if err := s.run(ctx); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if source.attempt != 3 {
+		t.Errorf("Expected 3 attempts, got %d", source.attempt)
+	}
+	if count := testutil.ToFloat64(promMetrics.SourceRestarts.WithLabelValues(exitStartFailed)); count != 1 {
+		t.Errorf("Expected 1 start_failed restart, got %v", count)
+	}
+	if count := testutil.ToFloat64(promMetrics.SourceRestarts.WithLabelValues(exitFailed)); count != 1 {
+		t.Errorf("Expected 1 failed restart, got %v", count)
+	}
+	if last := testutil.ToFloat64(promMetrics.SourceLastExit.WithLabelValues(exitFailed)); last != 1 {
+		t.Errorf("Expected the last exit reason to be failed, got %v", last)
+	}
+
+	// the SYN_RECV seen before the restart is matched by the ESTABLISHED after it
+	if c.allFlows.Len() != 1 {
+		t.Errorf("Expected the flow to survive the restart, got %d flows", c.allFlows.Len())
+	}
+}
+
+func TestSupervisorNotContinuous(t *testing.T) {
+	c := newTestCapture(t, 10)
+
+	s := newSupervisor(c.arguments, c, newServiceNotifier(c), nil)
+	s.newSource = func(arguments *loader.Args, promMetrics *exporter.PromMetrics) (EventSource, error) {
+		return &flakySource{}, nil
+	}
+
+	if err := s.run(context.Background()); err == nil {
+		t.Errorf("Expected the source error without continuous, got none")
+	}
+	if restarts := testutil.CollectAndCount(c.promMetrics.SourceRestarts); restarts != 0 {
+		t.Errorf("Expected no restarts recorded for a source that is not restarted, got %d", restarts)
+	}
+}
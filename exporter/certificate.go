@@ -0,0 +1,125 @@
+package exporter
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// how often the certificate files are checked for a renewal, SIGHUP checks straight away
+const certificateCheckInterval = 30 * time.Second
+
+// certificateReloader serves the exporter certificate through GetCertificate and swaps in a
+// renewed one when the files change, so ACME renewals do not need a restart
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mux         sync.RWMutex
+	certificate *tls.Certificate
+	notAfter    time.Time
+
+	// file stamps of the loaded pair and of the last pair that failed to load
+	loaded string
+	failed string
+}
+
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	reloader := &certificateReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := reloader.reload(true); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (r *certificateReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.certificate, nil
+}
+
+// expiry is the unix time the current certificate expires
+func (r *certificateReloader) expiry() float64 {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return float64(r.notAfter.Unix())
+}
+
+// reload loads the pair again if either file changed since it was last loaded, or always when forced.
+// On error the current certificate is kept
+func (r *certificateReloader) reload(force bool) (bool, error) {
+	stamp, err := r.stamp()
+	if err != nil {
+		return false, err
+	}
+	if !force && (stamp == r.loaded || stamp == r.failed) {
+		return false, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		// renewals often write the two files separately, retry once the stamp moves on
+		r.failed = stamp
+		return false, err
+	}
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		r.failed = stamp
+		return false, err
+	}
+
+	r.mux.Lock()
+	r.certificate = &certificate
+	r.notAfter = leaf.NotAfter
+	r.mux.Unlock()
+	r.loaded = stamp
+
+	return true, nil
+}
+
+// stamp identifies the current contents of both files by size and modification time
+func (r *certificateReloader) stamp() (string, error) {
+	stamp := ""
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		stamp += fmt.Sprintf("%d:%d;", info.Size(), info.ModTime().UnixNano())
+	}
+	return stamp, nil
+}
+
+// watch reloads changed certificates until ctx is done
+func (r *certificateReloader) watch(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		force := false
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-hup:
+			force = true
+		}
+
+		reloaded, err := r.reload(force)
+		if err != nil {
+			fmt.Printf("error reloading exporter certificate, keeping the current one: %v\n", err)
+		} else if reloaded {
+			fmt.Printf("exporter certificate reloaded from %s, expires %v\n", r.certFile, time.Unix(int64(r.expiry()), 0).UTC())
+		}
+	}
+}
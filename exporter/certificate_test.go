@@ -0,0 +1,61 @@
+package exporter
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCertificateReload(t *testing.T) {
+	pki := newTestPKI(t)
+	firstExpiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	pki.writeServerCert(firstExpiry)
+
+	reloader, err := newCertificateReloader(pki.file("server.crt"), pki.file("server.key"))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if reloader.expiry() != float64(firstExpiry.Unix()) {
+		t.Errorf("Expected expiry %v, got %v", firstExpiry.Unix(), reloader.expiry())
+	}
+
+	if reloaded, err := reloader.reload(false); reloaded || err != nil {
+		t.Errorf("Expected unchanged files to be left alone, got %v %v", reloaded, err)
+	}
+
+	// a renewal that has only written the certificate so far keeps the current pair
+	renewedExpiry := firstExpiry.Add(90 * 24 * time.Hour)
+	keyPEM, _ := os.ReadFile(pki.file("server.key"))
+	pki.writeServerCert(renewedExpiry)
+	renewedKeyPEM, _ := os.ReadFile(pki.file("server.key"))
+	if err := os.WriteFile(pki.file("server.key"), keyPEM, 0600); err != nil {
+		t.Fatalf("unable to write key: %v", err)
+	}
+	future := time.Now().Add(time.Second)
+	os.Chtimes(pki.file("server.crt"), future, future)
+
+	if reloaded, err := reloader.reload(false); reloaded || err == nil {
+		t.Errorf("Expected a mismatched pair to fail, got %v %v", reloaded, err)
+	}
+	if reloaded, err := reloader.reload(false); reloaded || err != nil {
+		t.Errorf("Expected the failed pair not to be retried until it changes, got %v %v", reloaded, err)
+	}
+	if reloader.expiry() != float64(firstExpiry.Unix()) {
+		t.Errorf("Expected the current certificate to be kept, got expiry %v", reloader.expiry())
+	}
+
+	if err := os.WriteFile(pki.file("server.key"), renewedKeyPEM, 0600); err != nil {
+		t.Fatalf("unable to write key: %v", err)
+	}
+	if reloaded, err := reloader.reload(false); !reloaded || err != nil {
+		t.Errorf("Expected the renewed pair to be loaded, got %v %v", reloaded, err)
+	}
+	if reloader.expiry() != float64(renewedExpiry.Unix()) {
+		t.Errorf("Expected expiry %v, got %v", renewedExpiry.Unix(), reloader.expiry())
+	}
+
+	certificate, _ := reloader.getCertificate(nil)
+	if certificate == nil {
+		t.Errorf("Expected a certificate to be served")
+	}
+}
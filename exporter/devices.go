@@ -0,0 +1,59 @@
+package exporter
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OtherDevices labels the combined series for every device outside the top max devices
+const OtherDevices = "other"
+
+// DeviceSeries are one device's values for a stats period
+type DeviceSeries struct {
+	Mean      float64
+	FlowCount float64
+}
+
+// DeviceMetrics exports the per device series. Each stats period replaces the whole set at once,
+// so a scrape sees either the previous period's devices or the new ones, never a mix or none
+type DeviceMetrics struct {
+	mean      *prometheus.Desc
+	flowCount *prometheus.Desc
+
+	mux     sync.Mutex
+	devices map[string]DeviceSeries
+}
+
+func newDeviceMetrics(reg *prometheus.Registry) *DeviceMetrics {
+	devices := &DeviceMetrics{
+		mean:      prometheus.NewDesc("lanRtt_device_mean_value", "lanRtt average value per device", []string{"device"}, nil),
+		flowCount: prometheus.NewDesc("lanRtt_device_flows_value", "lanRtt flow count per device", []string{"device"}, nil),
+	}
+	reg.MustRegister(devices)
+	return devices
+}
+
+// Set replaces the exported devices, those missing from devices are no longer exported
+func (d *DeviceMetrics) Set(devices map[string]DeviceSeries) {
+	d.mux.Lock()
+	d.devices = devices
+	d.mux.Unlock()
+}
+
+func (d *DeviceMetrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- d.mean
+	ch <- d.flowCount
+}
+
+func (d *DeviceMetrics) Collect(ch chan<- prometheus.Metric) {
+	d.mux.Lock()
+	devices := d.devices
+	d.mux.Unlock()
+
+	// Set swaps in a new map rather than changing this one, so it is read without the lock
+	for device, series := range devices {
+		ch <- prometheus.MustNewConstMetric(d.mean, prometheus.GaugeValue, series.Mean, device)
+		ch <- prometheus.MustNewConstMetric(d.flowCount, prometheus.GaugeValue, series.FlowCount, device)
+	}
+}
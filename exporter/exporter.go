@@ -1,26 +1,86 @@
 package exporter
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type PromMetrics struct {
-	MeanAll             prometheus.Gauge
-	MeanAggregated      prometheus.Gauge
-	MeanHisto           prometheus.Histogram
+	// snapshot metrics, recalculated every stats period
+	MeanAll        prometheus.Gauge
+	QuantileAll    *prometheus.GaugeVec
+	MaxAll         prometheus.Gauge
+	MeanAggregated prometheus.Gauge
+	DeviceCount    prometheus.Gauge
+
+	// top devices of each stats period, bounded by the max device setting
+	Devices *DeviceMetrics
+
+	// per device means of each stats period, observed once per period
 	MeanAggregatedHisto prometheus.Histogram
-	DeviceCount         prometheus.Gauge
+
+	// event-driven metrics, updated as events arrive and flows are matched
+	MeanHisto         prometheus.Histogram
+	HandshakesPending prometheus.Gauge
+	HandshakesMatched prometheus.Counter
+	HandshakesEvicted *prometheus.CounterVec
+	FilteredEvents    *prometheus.CounterVec
+
+	// raw event throughput: conntrack output lines, lines the event regex rejected, events by type
+	// before filtering, ESTABLISHED events without a pending SYN_RECV and flows pushed out of the buffer
+	ConntrackLines       prometheus.Counter
+	UnparsedLines        prometheus.Counter
+	Events               *prometheus.CounterVec
+	UnmatchedEstablished prometheus.Counter
+	BufferEvictions      prometheus.Counter
+
+	// SIGHUP reloads of the config file, by result
+	ConfigReloads *prometheus.CounterVec
+
+	// event source restarts by exit reason, and the reason of the last one
+	SourceRestarts *prometheus.CounterVec
+	SourceLastExit *prometheus.GaugeVec
+
+	// netlink buffer overruns reported by the event source, each one means lost events
+	SourceOverruns prometheus.Counter
+
+	// event source state for /readyz, nil when not serving it
+	Readiness *Readiness
+
+	// breakdown by address family and named subnet
+	Family GroupMetrics
+	Subnet GroupMetrics
+}
+
+// GroupMetrics are the series for one breakdown label, e.g. lanRtt_family_mean_value{family="ipv6"}
+type GroupMetrics struct {
+	Mean        *prometheus.GaugeVec
+	Quantile    *prometheus.GaugeVec
+	Max         *prometheus.GaugeVec
+	DeviceCount *prometheus.GaugeVec
+	Histo       *prometheus.HistogramVec
 }
 
+const shutdownTimeout = 5 * time.Second
+
 type ExporterOpts struct {
-	Port    string
-	SSLCert string
-	SSLKey  string
-	UseSSL  bool
+	Port         string
+	Listen       []ListenAddress
+	MetricsPath  string
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	SSLCert      string
+	SSLKey       string
+	UseSSL       bool
+	Web          *WebConfig
+	Readiness    *Readiness
 }
 
 func newGauge(reg *prometheus.Registry, name, help string) prometheus.Gauge {
@@ -30,50 +90,195 @@ func newGauge(reg *prometheus.Registry, name, help string) prometheus.Gauge {
 
 }
 
-func newHistogram(reg *prometheus.Registry, name, help string) prometheus.Histogram {
-	histo := prometheus.NewHistogram(prometheus.HistogramOpts{
-		Name:    name,
-		Help:    help,
-		Buckets: prometheus.LinearBuckets(5, 10, 20),
-	})
+func newCounter(reg *prometheus.Registry, name, help string) prometheus.Counter {
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: name, Help: help})
+	reg.MustRegister(counter)
+	return counter
+}
+
+func newCounterVec(reg *prometheus.Registry, name, help string, labels ...string) *prometheus.CounterVec {
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labels)
+	reg.MustRegister(counter)
+	return counter
+}
+
+func newGaugeVec(reg *prometheus.Registry, name, help string, labels ...string) *prometheus.GaugeVec {
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labels)
+	reg.MustRegister(gauge)
+	return gauge
+}
+
+func newHistogram(reg *prometheus.Registry, name, help string, buckets HistogramBuckets) prometheus.Histogram {
+	histo := prometheus.NewHistogram(buckets.opts(name, help))
+
+	reg.MustRegister(histo)
+
+	return histo
+}
+
+func newHistogramVec(reg *prometheus.Registry, name, help string, buckets HistogramBuckets, labels ...string) *prometheus.HistogramVec {
+	histo := prometheus.NewHistogramVec(buckets.opts(name, help), labels)
 
 	reg.MustRegister(histo)
 
 	return histo
 }
 
-func StartPromEndPoint(options ExporterOpts) *prometheus.Registry {
+// newEventCounter starts both event types at zero so rates exist before the first event
+func newEventCounter(reg *prometheus.Registry) *prometheus.CounterVec {
+	events := newCounterVec(reg, "lanRtt_events_total", "lanRtt conntrack events by type, before filtering", "type")
+	events.WithLabelValues("SYN_RECV")
+	events.WithLabelValues("ESTABLISHED")
+	return events
+}
+
+func newGroupMetrics(reg *prometheus.Registry, label string, buckets HistogramBuckets) GroupMetrics {
+	return GroupMetrics{
+		Mean:        newGaugeVec(reg, "lanRtt_"+label+"_mean_value", "lanRtt average value per "+label, label),
+		Quantile:    newGaugeVec(reg, "lanRtt_"+label+"_quantile_value", "lanRtt quantiles per "+label, label, "quantile"),
+		Max:         newGaugeVec(reg, "lanRtt_"+label+"_max_value", "lanRtt maximum value per "+label, label),
+		DeviceCount: newGaugeVec(reg, "lanRtt_"+label+"_unique_device_flows_value", "lanRtt unique device flow count value per "+label, label),
+		Histo:       newHistogramVec(reg, "lanRtt_"+label+"_flows_histo_value", "lanRtt flows histo values per "+label, buckets, label),
+	}
+}
+
+// Reset drops every group's series, e.g. when the subnets are reconfigured
+func (g GroupMetrics) Reset() {
+	g.Mean.Reset()
+	g.Quantile.Reset()
+	g.Max.Reset()
+	g.DeviceCount.Reset()
+	g.Histo.Reset()
+}
+
+// StartPromEndPoint returns once the exporter is listening on every address, scrapes are then
+// served in the background. Without listen addresses it listens on the port on every interface
+func StartPromEndPoint(options ExporterOpts) (*prometheus.Registry, *http.Server, error) {
 
 	reg := prometheus.NewRegistry()
+	server := &http.Server{
+		ReadTimeout:       options.ReadTimeout,
+		ReadHeaderTimeout: options.ReadTimeout,
+		WriteTimeout:      options.WriteTimeout,
+		IdleTimeout:       options.IdleTimeout,
+	}
+
+	addresses := options.Listen
+	if len(addresses) == 0 {
+		addresses = []ListenAddress{{Network: "tcp", Address: ":" + options.Port}}
+	}
+	metricsPath := options.MetricsPath
+	if metricsPath == "" {
+		metricsPath = "/metrics"
+	}
+
+	// usessl is shorthand for a tls_server_config with just a certificate and key
+	web := WebConfig{}
+	if options.Web != nil {
+		web = *options.Web
+	}
+	if web.TLSServerConfig == nil && options.UseSSL {
+		web.TLSServerConfig = &TLSServerConfig{CertFile: options.SSLCert, KeyFile: options.SSLKey}
+	}
+
+	tlsConfig, certificates, err := web.TLSServerConfig.tlsConfig()
+	if err != nil {
+		return nil, nil, fmt.Errorf("configuring exporter TLS: %v", err)
+	}
+	server.TLSConfig = tlsConfig
+
+	var metricsHandler http.Handler = promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg})
+	if len(web.BasicAuthUsers) > 0 {
+		metricsHandler = newBasicAuth(web.BasicAuthUsers, metricsHandler)
+	}
+
+	// a private mux, nothing else in the process can add handlers to the exporter
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, metricsHandler)
+	mux.HandleFunc("/healthz", healthz)
+	mux.Handle("/readyz", readyz(options.Readiness))
+	server.Handler = mux
 
-	go func(reg *prometheus.Registry) {
-		http.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg}))
-		if !options.UseSSL {
-			err := http.ListenAndServe(":"+options.Port, nil)
-			if err != nil {
-				fmt.Printf("error starting exporter listener: %v\n", err)
-				panic(1)
-			}
-		} else {
-			err := http.ListenAndServeTLS(":"+options.Port, options.SSLCert, options.SSLKey, nil)
-			if err != nil {
-				fmt.Printf("error starting exporter listener: %v\n", err)
-				panic(1)
-			}
-		}
-	}(reg)
+	listeners, err := listen(addresses)
+	if err != nil {
+		return nil, nil, fmt.Errorf("starting exporter listener: %v", err)
+	}
+
+	// renewed certificates are picked up until the exporter shuts down
+	if certificates != nil {
+		reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "lanRtt_exporter_cert_expiry_timestamp_seconds",
+			Help: "lanRtt unix time the exporter TLS certificate expires",
+		}, certificates.expiry))
+
+		watchCtx, stopWatch := context.WithCancel(context.Background())
+		server.RegisterOnShutdown(stopWatch)
+		go certificates.watch(watchCtx, certificateCheckInterval)
+	}
 
-	return reg
+	for i, listener := range listeners {
+		fmt.Printf("exporter listening on %s%s\n", addresses[i], metricsPath)
+		go serve(server, listener, tlsConfig != nil)
+	}
+
+	return reg, server, nil
 
 }
 
+// serve runs until the server is shut down, Shutdown closes every listener
+func serve(server *http.Server, listener net.Listener, useTLS bool) {
+	var err error
+	if useTLS {
+		// the certificate comes from server.TLSConfig.GetCertificate
+		err = server.ServeTLS(listener, "", "")
+	} else {
+		err = server.Serve(listener)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		fmt.Printf("error serving exporter on %s: %v\n", listener.Addr(), err)
+	}
+}
+
+// StopPromEndPoint lets in-flight scrapes finish before closing the listener
+func StopPromEndPoint(server *http.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
+
+// BuildPromMetrics registers every metric with the original histogram buckets
 func BuildPromMetrics(reg *prometheus.Registry) *PromMetrics {
+	return BuildPromMetricsWithHistograms(reg, nil)
+}
+
+// BuildPromMetricsWithHistograms registers every metric, with the histogram bucket layouts keyed by histogram name
+func BuildPromMetricsWithHistograms(reg *prometheus.Registry, histograms map[string]HistogramBuckets) *PromMetrics {
+	registerBuildInfo(reg)
+
 	return &PromMetrics{
-		MeanAll:             newGauge(reg, "lanRtt_mean_value", "lanRtt average value"),
-		MeanAggregated:      newGauge(reg, "lanRtt_aggregated_device_flows_mean_value", "lanRtt aggregated device flows average value"),
-		MeanHisto:           newHistogram(reg, "lanRtt_flows_histo_value", "lanRtt flows histo values"),
-		MeanAggregatedHisto: newHistogram(reg, "lanRtt_aggregated_device_flows_histo_value", "lanRtt aggregated device flows histo values"),
-		DeviceCount:         newGauge(reg, "lanRtt_unique_device_flows_value", "lanRtt unique device flow count value"),
+		MeanAll:              newGauge(reg, "lanRtt_mean_value", "lanRtt average value"),
+		QuantileAll:          newGaugeVec(reg, "lanRtt_quantile_value", "lanRtt quantiles over the quantile window", "quantile"),
+		MaxAll:               newGauge(reg, "lanRtt_max_value", "lanRtt maximum value over the quantile window"),
+		MeanAggregated:       newGauge(reg, "lanRtt_aggregated_device_flows_mean_value", "lanRtt aggregated device flows average value"),
+		MeanHisto:            newHistogram(reg, "lanRtt_flows_histo_value", "lanRtt flows histo values", histogramBuckets(histograms, HistogramFlows)),
+		MeanAggregatedHisto:  newHistogram(reg, "lanRtt_aggregated_device_flows_histo_value", "lanRtt aggregated device flows histo values", histogramBuckets(histograms, HistogramAggregated)),
+		DeviceCount:          newGauge(reg, "lanRtt_unique_device_flows_value", "lanRtt unique device flow count value"),
+		Devices:              newDeviceMetrics(reg),
+		HandshakesPending:    newGauge(reg, "lanRtt_pending_handshakes_value", "lanRtt SYN_RECV events waiting for their ESTABLISHED"),
+		HandshakesMatched:    newCounter(reg, "lanRtt_matched_handshakes_total", "lanRtt SYN_RECV events matched with their ESTABLISHED"),
+		HandshakesEvicted:    newCounterVec(reg, "lanRtt_evicted_handshakes_total", "lanRtt SYN_RECV events evicted before an ESTABLISHED arrived", "reason"),
+		FilteredEvents:       newCounterVec(reg, "lanRtt_filtered_events_total", "lanRtt events dropped by a deny rule", "rule"),
+		ConntrackLines:       newCounter(reg, "lanRtt_conntrack_lines_total", "lanRtt conntrack output lines read"),
+		UnparsedLines:        newCounter(reg, "lanRtt_conntrack_unparsed_lines_total", "lanRtt conntrack output lines that did not match the event regex"),
+		Events:               newEventCounter(reg),
+		UnmatchedEstablished: newCounter(reg, "lanRtt_unmatched_established_total", "lanRtt ESTABLISHED events without a pending SYN_RECV"),
+		BufferEvictions:      newCounter(reg, "lanRtt_buffer_evictions_total", "lanRtt flows dropped from the full flow buffer"),
+		ConfigReloads:        newCounterVec(reg, "lanRtt_config_reloads_total", "lanRtt config reloads by result", "result"),
+		SourceRestarts:       newCounterVec(reg, "lanRtt_source_restarts_total", "lanRtt event source restarts by exit reason", "reason"),
+		SourceLastExit:       newGaugeVec(reg, "lanRtt_source_last_exit_reason", "lanRtt reason the event source last stopped, 1 for the last reason", "reason"),
+		SourceOverruns:       newCounter(reg, "lanRtt_source_overruns_total", "lanRtt netlink buffer overruns reported by the event source, conntrack events were lost"),
+		Family:               newGroupMetrics(reg, "family", histogramBuckets(histograms, HistogramFamily)),
+		Subnet:               newGroupMetrics(reg, "subnet", histogramBuckets(histograms, HistogramSubnet)),
 	}
 
 }
@@ -0,0 +1,98 @@
+package exporter
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// set at build time, e.g. -ldflags "-X conntrack-lanrtt-analysis/exporter.Version=1.2.0 -X conntrack-lanrtt-analysis/exporter.Commit=abc123"
+var (
+	Version = ""
+	Commit  = "unknown"
+)
+
+// Readiness tracks whether the event source is running and still delivering events, for /readyz
+type Readiness struct {
+	lastEvent int64
+	running   int32
+	window    time.Duration
+}
+
+func NewReadiness(window time.Duration) *Readiness {
+	return &Readiness{window: window}
+}
+
+// SourceStarted and SourceStopped bracket each run of the event source
+func (r *Readiness) SourceStarted() {
+	if r != nil {
+		atomic.StoreInt32(&r.running, 1)
+	}
+}
+
+func (r *Readiness) SourceStopped() {
+	if r != nil {
+		atomic.StoreInt32(&r.running, 0)
+	}
+}
+
+// EventSeen is called for every conntrack event
+func (r *Readiness) EventSeen() {
+	if r != nil {
+		atomic.StoreInt64(&r.lastEvent, time.Now().UnixNano())
+	}
+}
+
+// Ready reports whether the source is running and has delivered an event within the window, and why not.
+// Without a Readiness nothing tracks the source, so it is never ready
+func (r *Readiness) Ready(now time.Time) (bool, string) {
+	if r == nil {
+		return false, "event source not tracked"
+	}
+	if atomic.LoadInt32(&r.running) == 0 {
+		return false, "event source not running"
+	}
+
+	lastEvent := atomic.LoadInt64(&r.lastEvent)
+	if lastEvent == 0 {
+		return false, "no events seen yet"
+	}
+	if since := now.Sub(time.Unix(0, lastEvent)); since > r.window {
+		return false, fmt.Sprintf("no events in the last %v", since.Truncate(time.Second))
+	}
+
+	return true, "ready"
+}
+
+// healthz answers as long as the process is serving requests
+func healthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+func readyz(readiness *Readiness) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ready, reason := readiness.Ready(time.Now())
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		fmt.Fprintln(w, reason)
+	}
+}
+
+func registerBuildInfo(reg *prometheus.Registry) {
+	version := Version
+	if info, ok := debug.ReadBuildInfo(); ok && version == "" {
+		version = info.Main.Version
+	}
+	if version == "" {
+		version = "unknown"
+	}
+
+	buildInfo := newGaugeVec(reg, "lanrtt_build_info", "lanRtt build information, always 1", "version", "commit", "goversion")
+	buildInfo.WithLabelValues(version, Commit, runtime.Version()).Set(1)
+}
@@ -0,0 +1,120 @@
+package exporter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestReadyz(t *testing.T) {
+	testCases := []struct {
+		name           string
+		prepare        func(readiness *Readiness)
+		expectedCode   int
+		expectedReason string
+	}{
+		{
+			name:           "NotStarted",
+			prepare:        func(readiness *Readiness) {},
+			expectedCode:   http.StatusServiceUnavailable,
+			expectedReason: "event source not running",
+		},
+		{
+			name: "NoEvents",
+			prepare: func(readiness *Readiness) {
+				readiness.SourceStarted()
+			},
+			expectedCode:   http.StatusServiceUnavailable,
+			expectedReason: "no events seen yet",
+		},
+		{
+			name: "Ready",
+			prepare: func(readiness *Readiness) {
+				readiness.SourceStarted()
+				readiness.EventSeen()
+			},
+			expectedCode:   http.StatusOK,
+			expectedReason: "ready",
+		},
+		{
+			name: "Stale",
+			prepare: func(readiness *Readiness) {
+				readiness.SourceStarted()
+				readiness.lastEvent = time.Now().Add(-2 * time.Minute).UnixNano()
+			},
+			expectedCode:   http.StatusServiceUnavailable,
+			expectedReason: "no events in the last",
+		},
+		{
+			name: "Stopped",
+			prepare: func(readiness *Readiness) {
+				readiness.SourceStarted()
+				readiness.EventSeen()
+				readiness.SourceStopped()
+			},
+			expectedCode:   http.StatusServiceUnavailable,
+			expectedReason: "event source not running",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			readiness := NewReadiness(time.Minute)
+			tc.prepare(readiness)
+
+			recorder := httptest.NewRecorder()
+			readyz(readiness)(recorder, httptest.NewRequest("GET", "/readyz", nil))
+
+			if recorder.Code != tc.expectedCode {
+				t.Errorf("Expected status %d, got %d", tc.expectedCode, recorder.Code)
+			}
+			if body := recorder.Body.String(); !strings.HasPrefix(body, tc.expectedReason) {
+				t.Errorf("Expected reason %q, got %q", tc.expectedReason, body)
+			}
+		})
+	}
+}
+
+func TestBuildInfo(t *testing.T) {
+	defer func(version string) { Version = version }(Version)
+	Version = "1.2.0"
+
+	reg := prometheus.NewRegistry()
+	registerBuildInfo(reg)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(families) != 1 || len(families[0].GetMetric()) != 1 {
+		t.Fatalf("Expected a single lanrtt_build_info series, got %v", families)
+	}
+
+	labels := make(map[string]string)
+	for _, label := range families[0].GetMetric()[0].GetLabel() {
+		labels[label.GetName()] = label.GetValue()
+	}
+
+	testCases := []struct {
+		name          string
+		label         string
+		expectedValue string
+	}{
+		{name: "Commit", label: "commit", expectedValue: Commit},
+		{name: "GoVersion", label: "goversion", expectedValue: runtime.Version()},
+		{name: "Version", label: "version", expectedValue: "1.2.0"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if value := labels[tc.label]; value != tc.expectedValue {
+				t.Errorf("Expected %s %q, got %q", tc.label, tc.expectedValue, value)
+			}
+		})
+	}
+}
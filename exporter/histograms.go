@@ -0,0 +1,137 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// histograms whose buckets can be configured, HistogramDefault applies to any not configured themselves
+const (
+	HistogramDefault    = "default"
+	HistogramFlows      = "flows"
+	HistogramAggregated = "aggregated"
+	HistogramFamily     = "family"
+	HistogramSubnet     = "subnet"
+)
+
+var histogramNames = []string{HistogramDefault, HistogramFlows, HistogramAggregated, HistogramFamily, HistogramSubnet}
+
+// with native histograms, bucket boundaries grow by at most this factor, about 8 buckets per doubling
+const (
+	defaultNativeFactor     = 1.1
+	defaultNativeMaxBuckets = 160
+	nativeMinResetDuration  = time.Hour
+)
+
+// HistogramBuckets is the bucket layout of one histogram, in ms: linear (start, width, count),
+// exponential (start, factor, count) or an explicit list of upper bounds. No layout keeps the
+// original 5ms to 195ms linear buckets. Native adds a Prometheus native histogram alongside the buckets
+type HistogramBuckets struct {
+	Layout           string    `json:"layout"`
+	Start            float64   `json:"start"`
+	Width            float64   `json:"width"`
+	Factor           float64   `json:"factor"`
+	Count            int       `json:"count"`
+	Buckets          []float64 `json:"buckets"`
+	Native           bool      `json:"native"`
+	NativeFactor     float64   `json:"nativefactor"`
+	NativeMaxBuckets uint32    `json:"nativemaxbuckets"`
+}
+
+// ValidateHistograms checks every configured histogram, reporting the first problem found
+func ValidateHistograms(histograms map[string]HistogramBuckets) error {
+	names := make([]string, 0, len(histograms))
+	for name := range histograms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !knownHistogram(name) {
+			return fmt.Errorf("unknown histogram %q, use %s", name, strings.Join(histogramNames, ", "))
+		}
+		if _, err := histograms[name].buckets(); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if histograms[name].NativeFactor != 0 && histograms[name].NativeFactor <= 1 {
+			return fmt.Errorf("%s: nativefactor must be above 1", name)
+		}
+	}
+
+	return nil
+}
+
+func knownHistogram(name string) bool {
+	for _, known := range histogramNames {
+		if name == known {
+			return true
+		}
+	}
+	return false
+}
+
+// histogramBuckets picks the layout configured for name, falling back to the default entry
+func histogramBuckets(histograms map[string]HistogramBuckets, name string) HistogramBuckets {
+	if buckets, present := histograms[name]; present {
+		return buckets
+	}
+	return histograms[HistogramDefault]
+}
+
+func (h HistogramBuckets) buckets() ([]float64, error) {
+	switch h.Layout {
+	case "":
+		return prometheus.LinearBuckets(5, 10, 20), nil
+	case "linear":
+		if h.Count < 1 || h.Width <= 0 {
+			return nil, errors.New("linear buckets need a count of at least 1 and a positive width")
+		}
+		return prometheus.LinearBuckets(h.Start, h.Width, h.Count), nil
+	case "exponential":
+		if h.Count < 1 || h.Start <= 0 || h.Factor <= 1 {
+			return nil, errors.New("exponential buckets need a count of at least 1, a positive start and a factor above 1")
+		}
+		return prometheus.ExponentialBuckets(h.Start, h.Factor, h.Count), nil
+	case "explicit":
+		if len(h.Buckets) == 0 {
+			return nil, errors.New("explicit buckets need a list of buckets")
+		}
+		for i := 1; i < len(h.Buckets); i++ {
+			if h.Buckets[i] <= h.Buckets[i-1] {
+				return nil, errors.New("explicit buckets must be in increasing order")
+			}
+		}
+		return h.Buckets, nil
+	default:
+		return nil, fmt.Errorf("unknown layout %q, use linear, exponential or explicit", h.Layout)
+	}
+}
+
+// opts are the histogram options for the layout, already checked by ValidateHistograms
+func (h HistogramBuckets) opts(name, help string) prometheus.HistogramOpts {
+	buckets, err := h.buckets()
+	if err != nil {
+		buckets = prometheus.LinearBuckets(5, 10, 20)
+	}
+
+	opts := prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets}
+	if h.Native {
+		opts.NativeHistogramBucketFactor = defaultNativeFactor
+		if h.NativeFactor > 1 {
+			opts.NativeHistogramBucketFactor = h.NativeFactor
+		}
+		opts.NativeHistogramMaxBucketNumber = defaultNativeMaxBuckets
+		if h.NativeMaxBuckets > 0 {
+			opts.NativeHistogramMaxBucketNumber = h.NativeMaxBuckets
+		}
+		// past the bucket limit resolution is lowered, and restored by a reset at most once an hour
+		opts.NativeHistogramMinResetDuration = nativeMinResetDuration
+	}
+
+	return opts
+}
@@ -0,0 +1,82 @@
+package exporter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	tests := []struct {
+		name     string
+		buckets  HistogramBuckets
+		expected []float64
+		problem  string
+	}{
+		{name: "original", buckets: HistogramBuckets{}, expected: prometheus.LinearBuckets(5, 10, 20)},
+		{name: "linear", buckets: HistogramBuckets{Layout: "linear", Start: 1, Width: 2, Count: 3}, expected: []float64{1, 3, 5}},
+		{name: "exponential", buckets: HistogramBuckets{Layout: "exponential", Start: 0.5, Factor: 2, Count: 4}, expected: []float64{0.5, 1, 2, 4}},
+		{name: "explicit", buckets: HistogramBuckets{Layout: "explicit", Buckets: []float64{1, 5, 50}}, expected: []float64{1, 5, 50}},
+		{name: "linear without width", buckets: HistogramBuckets{Layout: "linear", Count: 3}, problem: "positive width"},
+		{name: "exponential factor", buckets: HistogramBuckets{Layout: "exponential", Start: 1, Factor: 1, Count: 3}, problem: "factor above 1"},
+		{name: "explicit order", buckets: HistogramBuckets{Layout: "explicit", Buckets: []float64{5, 1}}, problem: "increasing"},
+		{name: "unknown layout", buckets: HistogramBuckets{Layout: "log"}, problem: "unknown layout"},
+	}
+
+	for _, test := range tests {
+		buckets, err := test.buckets.buckets()
+		if test.problem != "" {
+			if err == nil || !strings.Contains(err.Error(), test.problem) {
+				t.Errorf("%s: got %v, want an error containing %q", test.name, err, test.problem)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(buckets, test.expected) {
+			t.Errorf("%s: got %v %v, want %v", test.name, buckets, err, test.expected)
+		}
+	}
+}
+
+func TestValidateHistograms(t *testing.T) {
+	if err := ValidateHistograms(map[string]HistogramBuckets{"flows": {Layout: "explicit", Buckets: []float64{1, 2}}, "default": {Native: true}}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := ValidateHistograms(map[string]HistogramBuckets{"rtt": {}}); err == nil || !strings.Contains(err.Error(), "unknown histogram") {
+		t.Errorf("Expected an unknown histogram to be rejected, got %v", err)
+	}
+	if err := ValidateHistograms(map[string]HistogramBuckets{"flows": {Native: true, NativeFactor: 0.5}}); err == nil || !strings.Contains(err.Error(), "nativefactor") {
+		t.Errorf("Expected a native factor below 1 to be rejected, got %v", err)
+	}
+}
+
+func TestBuildPromMetricsWithHistograms(t *testing.T) {
+	promMetrics := BuildPromMetricsWithHistograms(prometheus.NewRegistry(), map[string]HistogramBuckets{
+		HistogramDefault: {Native: true},
+		HistogramFlows:   {Layout: "explicit", Buckets: []float64{1, 10, 100}},
+	})
+
+	promMetrics.MeanHisto.Observe(3)
+	promMetrics.Family.Histo.WithLabelValues("ipv4").Observe(3)
+
+	flows := histogram(t, promMetrics.MeanHisto)
+	if len(flows.GetBucket()) != 3 || flows.GetSchema() != 0 || len(flows.GetPositiveSpan()) != 0 {
+		t.Errorf("Expected 3 classic buckets and no native histogram, got %v", flows)
+	}
+
+	// the default entry applies to the family histogram, which keeps its original buckets
+	family := histogram(t, promMetrics.Family.Histo.WithLabelValues("ipv4").(prometheus.Metric))
+	if len(family.GetBucket()) != 20 || len(family.GetPositiveSpan()) == 0 {
+		t.Errorf("Expected 20 classic buckets and a native histogram, got %v", family)
+	}
+}
+
+func histogram(t *testing.T, metric prometheus.Metric) *dto.Histogram {
+	written := &dto.Metric{}
+	if err := metric.Write(written); err != nil {
+		t.Fatalf("unable to read histogram: %v", err)
+	}
+	return written.GetHistogram()
+}
@@ -0,0 +1,88 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+const unixPrefix = "unix:"
+
+// ListenAddress is one address the exporter listens on, a TCP host:port or a unix socket path
+type ListenAddress struct {
+	Network string
+	Address string
+}
+
+func (l ListenAddress) String() string {
+	if l.Network == "unix" {
+		return unixPrefix + l.Address
+	}
+	return l.Address
+}
+
+// ParseListenAddresses parses a comma separated list of host:port addresses and unix:/path sockets.
+// An empty host listens on every interface, e.g. :1986
+func ParseListenAddresses(listen string) ([]ListenAddress, error) {
+	addresses := make([]ListenAddress, 0)
+
+	for _, address := range strings.Split(listen, ",") {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+
+		if strings.HasPrefix(address, unixPrefix) {
+			path := strings.TrimPrefix(address, unixPrefix)
+			if path == "" {
+				return nil, errors.New("unix: needs a socket path, e.g. unix:/run/lanrtt.sock")
+			}
+			addresses = append(addresses, ListenAddress{Network: "unix", Address: path})
+			continue
+		}
+
+		if _, port, err := net.SplitHostPort(address); err != nil {
+			return nil, err
+		} else if port == "" {
+			return nil, fmt.Errorf("address %s: missing port", address)
+		}
+		addresses = append(addresses, ListenAddress{Network: "tcp", Address: address})
+	}
+
+	if len(addresses) == 0 {
+		return nil, errors.New("no listen address")
+	}
+	return addresses, nil
+}
+
+// listen opens every address, closing the ones already open if any fails
+func listen(addresses []ListenAddress) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, len(addresses))
+
+	for _, address := range addresses {
+		if address.Network == "unix" {
+			removeStaleSocket(address.Address)
+		}
+
+		listener, err := net.Listen(address.Network, address.Address)
+		if err != nil {
+			for _, open := range listeners {
+				open.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
+
+// removeStaleSocket removes a socket left behind by an unclean exit, the PID lock stops two
+// instances sharing it. Anything that is not a socket is left for net.Listen to fail on
+func removeStaleSocket(path string) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+}
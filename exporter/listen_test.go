@@ -0,0 +1,88 @@
+package exporter
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseListenAddresses(t *testing.T) {
+	tests := []struct {
+		listen      string
+		expected    []ListenAddress
+		expectError bool
+	}{
+		{listen: ":1986", expected: []ListenAddress{{"tcp", ":1986"}}},
+		{listen: "192.168.0.1:1986, [fd00::1]:1986", expected: []ListenAddress{{"tcp", "192.168.0.1:1986"}, {"tcp", "[fd00::1]:1986"}}},
+		{listen: "unix:/run/lanrtt.sock,127.0.0.1:1986", expected: []ListenAddress{{"unix", "/run/lanrtt.sock"}, {"tcp", "127.0.0.1:1986"}}},
+		{listen: "192.168.0.1", expectError: true},
+		{listen: "192.168.0.1:", expectError: true},
+		{listen: "unix:", expectError: true},
+		{listen: " , ", expectError: true},
+	}
+
+	for _, test := range tests {
+		addresses, err := ParseListenAddresses(test.listen)
+		if test.expectError {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", test.listen, addresses)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(addresses, test.expected) {
+			t.Errorf("%q: got %v %v, want %v", test.listen, addresses, err, test.expected)
+		}
+	}
+}
+
+func TestStartPromEndPoint(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "lanrtt.sock")
+
+	_, server, err := StartPromEndPoint(ExporterOpts{
+		Listen:      []ListenAddress{{"unix", socket}, {"tcp", "127.0.0.1:0"}},
+		MetricsPath: "/lanrtt/metrics",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer StopPromEndPoint(server)
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+
+	for path, code := range map[string]int{"/lanrtt/metrics": http.StatusOK, "/metrics": http.StatusNotFound, "/healthz": http.StatusOK, "/readyz": http.StatusServiceUnavailable} {
+		response, err := client.Get("http://lanrtt" + path)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", path, err)
+		}
+		response.Body.Close()
+		if response.StatusCode != code {
+			t.Errorf("%s: got %d, want %d", path, response.StatusCode, code)
+		}
+	}
+
+	// nothing is registered on the default mux
+	request, _ := http.NewRequest("GET", "http://lanrtt/lanrtt/metrics", nil)
+	if _, pattern := http.DefaultServeMux.Handler(request); pattern != "" {
+		t.Errorf("Expected the default mux to be left alone, got %q registered", pattern)
+	}
+}
+
+func TestStartPromEndPointAddressInUse(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer busy.Close()
+
+	_, _, err = StartPromEndPoint(ExporterOpts{Listen: []ListenAddress{{"tcp", busy.Addr().String()}}})
+	if err == nil {
+		t.Errorf("Expected an error for an address in use")
+	}
+}
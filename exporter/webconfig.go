@@ -0,0 +1,296 @@
+package exporter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// WebConfig is the TLS and basic auth part of a Prometheus exporter-toolkit web config file,
+// see https://github.com/prometheus/exporter-toolkit/blob/master/docs/web-configuration.md
+type WebConfig struct {
+	TLSServerConfig *TLSServerConfig  `json:"tls_server_config" yaml:"tls_server_config"`
+	BasicAuthUsers  map[string]string `json:"basic_auth_users" yaml:"basic_auth_users"`
+
+	// accepted so existing toolkit files load, its response headers and HTTP/2 switch are not applied
+	HTTPServerConfig interface{} `json:"http_server_config" yaml:"http_server_config"`
+}
+
+type TLSServerConfig struct {
+	CertFile                 string   `json:"cert_file" yaml:"cert_file"`
+	KeyFile                  string   `json:"key_file" yaml:"key_file"`
+	ClientAuthType           string   `json:"client_auth_type" yaml:"client_auth_type"`
+	ClientCAFile             string   `json:"client_ca_file" yaml:"client_ca_file"`
+	ClientAllowedSans        []string `json:"client_allowed_sans" yaml:"client_allowed_sans"`
+	MinVersion               string   `json:"min_version" yaml:"min_version"`
+	MaxVersion               string   `json:"max_version" yaml:"max_version"`
+	CipherSuites             []string `json:"cipher_suites" yaml:"cipher_suites"`
+	PreferServerCipherSuites bool     `json:"prefer_server_cipher_suites" yaml:"prefer_server_cipher_suites"`
+	CurvePreferences         []string `json:"curve_preferences" yaml:"curve_preferences"`
+}
+
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+var curves = map[string]tls.CurveID{
+	"CurveP256": tls.CurveP256,
+	"CurveP384": tls.CurveP384,
+	"CurveP521": tls.CurveP521,
+	"X25519":    tls.X25519,
+}
+
+// LoadWebConfig reads an exporter-toolkit web config file, rejecting keys the toolkit does not know either
+func LoadWebConfig(webConfigFile string) (*WebConfig, error) {
+	byteValue, err := os.ReadFile(webConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	webConfig := new(WebConfig)
+	decoder := yaml.NewDecoder(bytes.NewReader(byteValue))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(webConfig); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return webConfig, nil
+}
+
+// Validate loads the certificates and checks the TLS policy and password hashes
+func (c *WebConfig) Validate() error {
+	if _, _, err := c.TLSServerConfig.tlsConfig(); err != nil {
+		return err
+	}
+
+	for _, user := range sortedUsers(c.BasicAuthUsers) {
+		if _, err := bcrypt.Cost([]byte(c.BasicAuthUsers[user])); err != nil {
+			return fmt.Errorf("basic_auth_users: %s: password must be a bcrypt hash: %v", user, err)
+		}
+	}
+
+	return nil
+}
+
+// tlsConfig builds the server TLS config, nil when TLS is not configured. The certificate comes
+// from the returned reloader. Like exporter-toolkit at least TLS 1.2 is required unless min_version says otherwise
+func (c *TLSServerConfig) tlsConfig() (*tls.Config, *certificateReloader, error) {
+	if c == nil {
+		return nil, nil, nil
+	}
+
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, nil, errors.New("tls_server_config: cert_file and key_file are both needed")
+	}
+	reloader, err := newCertificateReloader(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tls_server_config: %v", err)
+	}
+
+	config := &tls.Config{
+		GetCertificate:           reloader.getCertificate,
+		MinVersion:               tls.VersionTLS12,
+		PreferServerCipherSuites: c.PreferServerCipherSuites,
+	}
+
+	if c.MinVersion != "" {
+		version, known := tlsVersions[c.MinVersion]
+		if !known {
+			return nil, nil, fmt.Errorf("tls_server_config: unknown min_version %q, use TLS10, TLS11, TLS12 or TLS13", c.MinVersion)
+		}
+		config.MinVersion = version
+	}
+	if c.MaxVersion != "" {
+		version, known := tlsVersions[c.MaxVersion]
+		if !known {
+			return nil, nil, fmt.Errorf("tls_server_config: unknown max_version %q, use TLS10, TLS11, TLS12 or TLS13", c.MaxVersion)
+		}
+		config.MaxVersion = version
+	}
+	if config.MaxVersion != 0 && config.MaxVersion < config.MinVersion {
+		return nil, nil, errors.New("tls_server_config: max_version is below min_version")
+	}
+
+	for _, name := range c.CipherSuites {
+		id, known := cipherSuite(name)
+		if !known {
+			return nil, nil, fmt.Errorf("tls_server_config: unknown cipher suite %q", name)
+		}
+		config.CipherSuites = append(config.CipherSuites, id)
+	}
+	for _, name := range c.CurvePreferences {
+		curve, known := curves[name]
+		if !known {
+			return nil, nil, fmt.Errorf("tls_server_config: unknown curve %q", name)
+		}
+		config.CurvePreferences = append(config.CurvePreferences, curve)
+	}
+
+	// as in exporter-toolkit a client CA on its own does not ask clients for a certificate
+	clientAuth := c.ClientAuthType
+	if clientAuth != "" {
+		authType, known := clientAuthTypes[clientAuth]
+		if !known {
+			return nil, nil, fmt.Errorf("tls_server_config: unknown client_auth_type %q", clientAuth)
+		}
+		config.ClientAuth = authType
+	}
+
+	if c.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("tls_server_config: %v", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("tls_server_config: no certificates found in %s", c.ClientCAFile)
+		}
+	} else if config.ClientAuth == tls.VerifyClientCertIfGiven || config.ClientAuth == tls.RequireAndVerifyClientCert {
+		return nil, nil, fmt.Errorf("tls_server_config: client_auth_type %s needs a client_ca_file", clientAuth)
+	}
+
+	if len(c.ClientAllowedSans) > 0 {
+		if config.ClientAuth != tls.RequireAndVerifyClientCert {
+			return nil, nil, errors.New("tls_server_config: client_allowed_sans needs client_auth_type RequireAndVerifyClientCert")
+		}
+		config.VerifyPeerCertificate = c.verifyClientSans
+	}
+
+	return config, reloader, nil
+}
+
+// verifyClientSans accepts a verified client certificate only when one of its DNS, email, IP or URI
+// SANs is in client_allowed_sans, as exporter-toolkit does
+func (c *TLSServerConfig) verifyClientSans(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("no client certificate")
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+
+	sans := append(append([]string{}, cert.DNSNames...), cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+
+	for _, san := range sans {
+		for _, allowed := range c.ClientAllowedSans {
+			if san == allowed {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("client certificate SANs %v are not in client_allowed_sans", sans)
+}
+
+func cipherSuite(name string) (uint16, bool) {
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+	return 0, false
+}
+
+func sortedUsers(users map[string]string) []string {
+	names := make([]string, 0, len(users))
+	for user := range users {
+		names = append(names, user)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// most scrapers only ever send a handful of credentials
+const maxVerifiedLogins = 64
+
+// basicAuth checks credentials against bcrypt hashes. bcrypt is deliberately slow, so
+// credentials that checked out are remembered rather than hashed again every scrape
+type basicAuth struct {
+	users     map[string]string
+	dummyHash []byte
+	next      http.Handler
+
+	mux      sync.Mutex
+	verified map[[sha256.Size]byte]bool
+}
+
+func newBasicAuth(users map[string]string, next http.Handler) *basicAuth {
+	// unknown users are checked against a throwaway hash so they take as long as known ones
+	dummyHash, _ := bcrypt.GenerateFromPassword([]byte("lanrtt"), bcrypt.DefaultCost)
+
+	return &basicAuth{
+		users:     users,
+		dummyHash: dummyHash,
+		next:      next,
+		verified:  make(map[[sha256.Size]byte]bool),
+	}
+}
+
+func (a *basicAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, password, ok := r.BasicAuth()
+	if ok && a.verify(user, password) {
+		a.next.ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("WWW-Authenticate", `Basic realm="lanrtt"`)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+func (a *basicAuth) verify(user, password string) bool {
+	hash, known := a.users[user]
+	if !known {
+		bcrypt.CompareHashAndPassword(a.dummyHash, []byte(password))
+		return false
+	}
+
+	login := sha256.Sum256([]byte(strings.Join([]string{user, password, hash}, "\x00")))
+	a.mux.Lock()
+	verified := a.verified[login]
+	a.mux.Unlock()
+	if verified {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false
+	}
+
+	a.mux.Lock()
+	if len(a.verified) >= maxVerifiedLogins {
+		a.verified = make(map[[sha256.Size]byte]bool)
+	}
+	a.verified[login] = true
+	a.mux.Unlock()
+
+	return true
+}
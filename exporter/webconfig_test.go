@@ -0,0 +1,299 @@
+package exporter
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testPKI writes a CA and a server certificate for 127.0.0.1, and holds a client certificate signed by the CA
+type testPKI struct {
+	t          *testing.T
+	dir        string
+	caCert     *x509.Certificate
+	caKey      *ecdsa.PrivateKey
+	caPool     *x509.CertPool
+	clientCert tls.Certificate
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	pki := &testPKI{t: t, dir: t.TempDir()}
+
+	pki.caKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "lanrtt test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &pki.caKey.PublicKey, pki.caKey)
+	if err != nil {
+		t.Fatalf("unable to create CA: %v", err)
+	}
+	pki.caCert, _ = x509.ParseCertificate(caDER)
+	pki.caPool = x509.NewCertPool()
+	pki.caPool.AddCert(pki.caCert)
+	pki.writePEM("ca.crt", "CERTIFICATE", caDER)
+
+	pki.writeServerCert(time.Now().Add(time.Hour))
+
+	clientDER, clientKey := pki.issue(x509.ExtKeyUsageClientAuth, time.Now().Add(time.Hour))
+	pki.clientCert = tls.Certificate{Certificate: [][]byte{clientDER}, PrivateKey: clientKey}
+
+	return pki
+}
+
+func (p *testPKI) issue(usage x509.ExtKeyUsage, notAfter time.Time) ([]byte, *ecdsa.PrivateKey) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "lanrtt test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, p.caCert, &key.PublicKey, p.caKey)
+	if err != nil {
+		p.t.Fatalf("unable to create certificate: %v", err)
+	}
+	return der, key
+}
+
+// writeServerCert replaces server.crt and server.key with a new pair
+func (p *testPKI) writeServerCert(notAfter time.Time) {
+	der, key := p.issue(x509.ExtKeyUsageServerAuth, notAfter)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	p.writePEM("server.crt", "CERTIFICATE", der)
+	p.writePEM("server.key", "EC PRIVATE KEY", keyDER)
+}
+
+func (p *testPKI) writePEM(name, blockType string, der []byte) {
+	if err := os.WriteFile(p.file(name), pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		p.t.Fatalf("unable to write %s: %v", name, err)
+	}
+}
+
+func (p *testPKI) file(name string) string {
+	return filepath.Join(p.dir, name)
+}
+
+func TestTLSConfigValidation(t *testing.T) {
+	pki := newTestPKI(t)
+
+	tests := []struct {
+		name     string
+		modify   func(c *TLSServerConfig)
+		expected string
+	}{
+		{"valid", func(c *TLSServerConfig) {}, ""},
+		{"missing key", func(c *TLSServerConfig) { c.KeyFile = "" }, "cert_file and key_file"},
+		{"min version", func(c *TLSServerConfig) { c.MinVersion = "TLS14" }, "min_version"},
+		{"max below min", func(c *TLSServerConfig) { c.MinVersion = "TLS13"; c.MaxVersion = "TLS12" }, "below min_version"},
+		{"cipher suite", func(c *TLSServerConfig) { c.CipherSuites = []string{"TLS_RSA_WITH_NOTHING"} }, "cipher suite"},
+		{"known cipher suite", func(c *TLSServerConfig) { c.CipherSuites = []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"} }, ""},
+		{"client auth type", func(c *TLSServerConfig) { c.ClientAuthType = "Sometimes" }, "client_auth_type"},
+		{"verify without CA", func(c *TLSServerConfig) { c.ClientAuthType = "RequireAndVerifyClientCert" }, "needs a client_ca_file"},
+		{"CA without certificates", func(c *TLSServerConfig) { c.ClientCAFile = c.KeyFile }, "no certificates"},
+		{"allowed SANs without verify", func(c *TLSServerConfig) {
+			c.ClientCAFile = pki.file("ca.crt")
+			c.ClientAllowedSans = []string{"prometheus"}
+		}, "client_allowed_sans needs"},
+	}
+
+	for _, test := range tests {
+		config := &TLSServerConfig{CertFile: pki.file("server.crt"), KeyFile: pki.file("server.key")}
+		test.modify(config)
+
+		_, _, err := config.tlsConfig()
+		if test.expected == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if test.expected != "" && (err == nil || !strings.Contains(err.Error(), test.expected)) {
+			t.Errorf("%s: got %v, want an error containing %q", test.name, err, test.expected)
+		}
+	}
+}
+
+func TestClientCertificates(t *testing.T) {
+	pki := newTestPKI(t)
+
+	server := startTLSServer(t, &TLSServerConfig{
+		CertFile:       pki.file("server.crt"),
+		KeyFile:        pki.file("server.key"),
+		ClientAuthType: "RequireAndVerifyClientCert",
+		ClientCAFile:   pki.file("ca.crt"),
+	})
+	defer server.Close()
+
+	for _, withCert := range []bool{true, false} {
+		err := pki.get(server.URL, withCert)
+		if withCert && err != nil {
+			t.Errorf("Expected a client with a certificate to be accepted, got %v", err)
+		}
+		if !withCert && err == nil {
+			t.Errorf("Expected a client without a certificate to be rejected")
+		}
+	}
+}
+
+func TestClientCADefault(t *testing.T) {
+	pki := newTestPKI(t)
+
+	// exporter-toolkit only asks for client certificates when client_auth_type says so
+	tlsConfig, _, err := (&TLSServerConfig{
+		CertFile:     pki.file("server.crt"),
+		KeyFile:      pki.file("server.key"),
+		ClientCAFile: pki.file("ca.crt"),
+	}).tlsConfig()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if tlsConfig.ClientAuth != tls.NoClientCert {
+		t.Errorf("Expected NoClientCert with only a client_ca_file, got %v", tlsConfig.ClientAuth)
+	}
+}
+
+func TestClientAllowedSans(t *testing.T) {
+	pki := newTestPKI(t)
+
+	testCases := []struct {
+		name             string
+		allowedSans      []string
+		expectedAccepted bool
+	}{
+		{name: "AllowedIP", allowedSans: []string{"prometheus.lan", "127.0.0.1"}, expectedAccepted: true},
+		{name: "OtherSans", allowedSans: []string{"prometheus.lan"}, expectedAccepted: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := startTLSServer(t, &TLSServerConfig{
+				CertFile:          pki.file("server.crt"),
+				KeyFile:           pki.file("server.key"),
+				ClientAuthType:    "RequireAndVerifyClientCert",
+				ClientCAFile:      pki.file("ca.crt"),
+				ClientAllowedSans: tc.allowedSans,
+			})
+			defer server.Close()
+
+			if err := pki.get(server.URL, true); (err == nil) != tc.expectedAccepted {
+				t.Errorf("Expected accepted %v, got error %v", tc.expectedAccepted, err)
+			}
+		})
+	}
+}
+
+// startTLSServer serves healthz with the TLS settings of config
+func startTLSServer(t *testing.T, config *TLSServerConfig) *httptest.Server {
+	tlsConfig, _, err := config.tlsConfig()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// httptest fills in its own certificate if none is set, which would win over GetCertificate
+	certificate, _ := tlsConfig.GetCertificate(&tls.ClientHelloInfo{})
+	tlsConfig.Certificates = []tls.Certificate{*certificate}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(healthz))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	return server
+}
+
+// get requests url trusting the test CA, presenting the client certificate when withCert is set
+func (p *testPKI) get(url string, withCert bool) error {
+	clientConfig := &tls.Config{RootCAs: p.caPool}
+	if withCert {
+		clientConfig.Certificates = []tls.Certificate{p.clientCert}
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+
+	response, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+func TestBasicAuth(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	auth := newBasicAuth(map[string]string{"prometheus": string(hash)}, http.HandlerFunc(healthz))
+
+	tests := []struct {
+		user, password string
+		code           int
+	}{
+		{"prometheus", "secret", http.StatusOK},
+		{"prometheus", "secret", http.StatusOK},
+		{"prometheus", "wrong", http.StatusUnauthorized},
+		{"grafana", "secret", http.StatusUnauthorized},
+		{"", "", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest("GET", "/metrics", nil)
+		if test.user != "" {
+			request.SetBasicAuth(test.user, test.password)
+		}
+		recorder := httptest.NewRecorder()
+		auth.ServeHTTP(recorder, request)
+
+		if recorder.Code != test.code {
+			t.Errorf("%s/%s: got %d, want %d", test.user, test.password, recorder.Code, test.code)
+		}
+	}
+}
+
+func TestLoadWebConfig(t *testing.T) {
+	webConfigFile := filepath.Join(t.TempDir(), "web.yml")
+	content := "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  min_version: TLS13\nbasic_auth_users:\n  prometheus: $2y$10$X\n"
+	if err := os.WriteFile(webConfigFile, []byte(content), 0644); err != nil {
+		t.Fatalf("unable to write web config: %v", err)
+	}
+
+	webConfig, err := LoadWebConfig(webConfigFile)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if webConfig.TLSServerConfig.MinVersion != "TLS13" || webConfig.BasicAuthUsers["prometheus"] != "$2y$10$X" {
+		t.Errorf("got %+v", webConfig)
+	}
+
+	// toolkit settings that are not applied still load, unknown keys do not
+	content = "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  client_allowed_sans: [prometheus.lan]\nhttp_server_config:\n  http2: false\n"
+	if err := os.WriteFile(webConfigFile, []byte(content), 0644); err != nil {
+		t.Fatalf("unable to write web config: %v", err)
+	}
+	if _, err := LoadWebConfig(webConfigFile); err != nil {
+		t.Errorf("Expected a toolkit web config to load, got %v", err)
+	}
+
+	if err := os.WriteFile(webConfigFile, []byte("tls_server_config:\n  certfile: server.crt\n"), 0644); err != nil {
+		t.Fatalf("unable to write web config: %v", err)
+	}
+	if _, err := LoadWebConfig(webConfigFile); err == nil {
+		t.Errorf("Expected an unknown key to be rejected")
+	}
+}
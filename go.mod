@@ -1,5 +1,14 @@
-module conntrack-lanrtt-analysis/lanrtt
+module conntrack-lanrtt-analysis
 
 go 1.16
 
-require github.com/prometheus/client_golang v1.15.0
+require (
+	github.com/BurntSushi/toml v1.3.2
+	github.com/grafana/pyroscope-go v1.1.1
+	github.com/prometheus/client_golang v1.15.0
+	github.com/prometheus/client_model v0.3.0
+	golang.org/x/crypto v0.8.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require github.com/grafana/pyroscope-go/godeltaprof v0.1.9 // indirect
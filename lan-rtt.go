@@ -2,16 +2,53 @@ package main
 
 import (
 	"conntrack-lanrtt-analysis/conntrack"
+	"conntrack-lanrtt-analysis/exporter"
+	"context"
+	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"conntrack-lanrtt-analysis/loader"
 )
 
 func main() {
 
-	args, promMetrics := loader.Startup()
-	conntrack.Poller(args, promMetrics)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	defer os.Remove(args.PidFile)
+	// restore default signal handling once stopping so a second signal kills a stuck shutdown
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	// SIGHUP reloads the config file
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+
+	args, promMetrics, promServer, err := loader.Startup()
+	if err != nil {
+		fmt.Printf("error starting exporter: %v\n", err)
+		loader.RemovePID(args.PidFile)
+		os.Exit(loader.ExitFailure)
+	}
+
+	err = conntrack.Poller(ctx, args, promMetrics, reloads)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+	}
+
+	if err := exporter.StopPromEndPoint(promServer); err != nil {
+		fmt.Printf("error stopping exporter: %v\n", err)
+	}
+
+	loader.RemovePID(args.PidFile)
+	fmt.Println("exiting...")
+
+	if err != nil {
+		os.Exit(loader.ExitFailure)
+	}
+	os.Exit(loader.ExitOK)
 
 }
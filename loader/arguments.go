@@ -1,84 +1,191 @@
 package loader
 
 import (
+	"bytes"
+	"conntrack-lanrtt-analysis/exporter"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
+	"strings"
 )
 
 type Args struct {
-	Network       string `json:"network"`
-	Subnet        string `json:"subnetmask"`
-	RunContinuous bool   `json:"runcontinuous"`
-	BufferSize    int    `json:"buffersize"`
-	StatsPeriod   int    `json:"statsperiod"`
-	PollTime      int64  `json:"pollingtime"`
-	PromPort      string `json:"promport"`
-	Debug         bool   `json:"debug"`
-	StatsOut      bool   `json:"statsout"`
-	SSLCert       string `json:"sslcert"`
-	SSLKey        string `json:"sslkey"`
-	UseSSL        bool   `json:"usessl"`
-	PyroScope     bool   `json:"pyroscope"`
-	PyroScopeHost string `json:"pyroscopehost"`
-	PidFile       string `json:"pidfile"`
+	Network         string                               `json:"network"`
+	Subnet          string                               `json:"subnetmask"`
+	RunContinuous   bool                                 `json:"runcontinuous"`
+	BufferSize      int                                  `json:"buffersize"`
+	StatsPeriod     int                                  `json:"statsperiod"`
+	PollTime        int64                                `json:"pollingtime"`
+	PromPort        string                               `json:"promport"`
+	Listen          string                               `json:"listen"`
+	MetricsPath     string                               `json:"metricspath"`
+	ReadTimeout     int                                  `json:"readtimeout"`
+	WriteTimeout    int                                  `json:"writetimeout"`
+	IdleTimeout     int                                  `json:"idletimeout"`
+	ReadyWindow     int                                  `json:"readywindow"`
+	Debug           bool                                 `json:"debug"`
+	StatsOut        bool                                 `json:"statsout"`
+	SSLCert         string                               `json:"sslcert"`
+	SSLKey          string                               `json:"sslkey"`
+	UseSSL          bool                                 `json:"usessl"`
+	WebConfigFile   string                               `json:"webconfig"`
+	TLSServerConfig *exporter.TLSServerConfig            `json:"tls_server_config"` // exporter-toolkit web config key style
+	BasicAuthUsers  map[string]string                    `json:"basic_auth_users"`
+	Histograms      map[string]exporter.HistogramBuckets `json:"histograms"`
+	PyroScope       bool                                 `json:"pyroscope"`
+	PyroScopeHost   string                               `json:"pyroscopehost"`
+	PidFile         string                               `json:"pidfile"`
+	Source          string                               `json:"source"`
+	ReplayFile      string                               `json:"replayfile"`
+	ReplaySpeed     float64                              `json:"replayspeed"`
+	NetlinkBuffer   int                                  `json:"netlinkbuffer"`
+	HandshakeTTL    int                                  `json:"handshaketimeout"`
+	MaxPending      int                                  `json:"maxpending"`
+	Quantiles       []float64                            `json:"quantiles"`
+	QuantileWin     int                                  `json:"quantilewindow"`
+	Subnets         []Subnet                             `json:"subnets"`
+	Rules           []Rule                               `json:"rules"`
+	DeviceMetrics   bool                                 `json:"devicemetrics"`
+	MaxDevices      int                                  `json:"maxdevices"`
+	DeviceTopBy     string                               `json:"devicetopby"`
+	DeviceNames     map[string]string                    `json:"devicenames"`
+	ConfigFile      string                               `json:"-"`
+	ConfigFormat    string                               `json:"-"`
+
+	// flags set on the command line, applied again over the file on reload
+	flagValues map[string]string
+}
+
+// defaults for settings that older JSON config files may not set
+const (
+	defaultHandshakeTTL = 30
+	defaultMaxPending   = 100000
+	defaultQuantiles    = "0.5,0.9,0.95,0.99"
+	defaultQuantileWin  = 60
+	defaultMaxDevices   = 20
+	defaultDeviceTopBy  = "flows"
+	defaultNetlinkBuf   = 1064960
+	defaultReadyWindow  = 60
+	defaultReadTimeout  = 10
+	defaultWriteTimeout = 30
+	defaultIdleTimeout  = 120
+)
+
+// defaultArgs are the built-in settings, the bottom layer under the config file, environment and flags
+func defaultArgs() *Args {
+	quantiles, _ := parseQuantiles(defaultQuantiles)
+	return &Args{
+		Network:       "127.0.0.1",
+		Subnet:        "255.255.240.0",
+		BufferSize:    2000,
+		StatsPeriod:   5,
+		PollTime:      300,
+		PromPort:      "1986",
+		MetricsPath:   "/metrics",
+		ReadTimeout:   defaultReadTimeout,
+		WriteTimeout:  defaultWriteTimeout,
+		IdleTimeout:   defaultIdleTimeout,
+		ReadyWindow:   defaultReadyWindow,
+		PyroScopeHost: "http://pyroscope-host:4040",
+		PidFile:       "/run/lanrtt.pid",
+		Source:        "conntrack",
+		ReplaySpeed:   1,
+		NetlinkBuffer: defaultNetlinkBuf,
+		HandshakeTTL:  defaultHandshakeTTL,
+		MaxPending:    defaultMaxPending,
+		Quantiles:     quantiles,
+		QuantileWin:   defaultQuantileWin,
+		MaxDevices:    defaultMaxDevices,
+		DeviceTopBy:   defaultDeviceTopBy,
+	}
 }
 
 func ArgParse(arguments *Args) {
 
-	// takes cli arguments or loads from json config file
-
-	network := flag.String("network", "127.0.0.1", "network address to filter for")
-	subnet := flag.String("mask", "255.255.240.0", "subnet mask to use")
-	runContinuous := flag.Bool("continuous", false, "run continuously")
-	bufferSize := flag.Int("buffersize", 2000, "number of events to buffer for calculations")
-	statsPeriod := flag.Int("statsperiod", 5, "output stats every x seconds")
-	pollTime := flag.Int64("pollingtime", 300, "duration in seconds to poll for")
-	promPort := flag.String("promport", "1986", "port for prom exporter to listen on")
-	debug := flag.Bool("debug", false, "enabling debugging")
-	statsOut := flag.Bool("statsout", false, "output stats updates to stdout")
-	sslCert := flag.String("sslcert", "", "path to SSL cert to use for prom exporter")
-	sslKey := flag.String("sslkey", "", "path to SSL priv key to use for prom exporter")
-	useSsl := flag.Bool("usessl", false, "set to use HTTP and not HTTPS for Prom exporter")
-	pyroscope := flag.Bool("pyroscope", false, "sent application metrics to remote pyroschope host")
-	pyroscopeHost := flag.String("pyroscopehost", "http://pyroscope-host:4040", "remote pyroscope host to uset")
-	pidFile := flag.String("pidfile", "/run/lanrtt.pid", "pid file to use")
-
-	config := flag.String("loadconfig", "none", "load json config file")
+	// built-in defaults, then the json config file, then LANRTT_* environment variables, then flags set on the command line
+
+	defaults := defaultArgs()
+
+	flag.String("network", defaults.Network, "networks to filter for in CIDR notation, comma separated, a bare address uses -mask")
+	flag.String("mask", defaults.Subnet, "subnet mask to use with a bare IPv4 network address")
+	flag.Bool("continuous", defaults.RunContinuous, "run continuously")
+	flag.Int("buffersize", defaults.BufferSize, "number of events to buffer for calculations")
+	flag.Int("statsperiod", defaults.StatsPeriod, "output stats every x seconds")
+	flag.Int64("pollingtime", defaults.PollTime, "duration in seconds to poll for")
+	flag.String("promport", defaults.PromPort, "port for prom exporter to listen on")
+	flag.String("listen", defaults.Listen, "comma separated host:port addresses or unix:/path sockets for prom exporter to listen on instead of -promport on every interface")
+	flag.String("metricspath", defaults.MetricsPath, "path prom exporter serves metrics on")
+	flag.Int("readtimeout", defaults.ReadTimeout, "seconds prom exporter allows for reading a request, 0 for no limit")
+	flag.Int("writetimeout", defaults.WriteTimeout, "seconds prom exporter allows for writing a response, 0 for no limit")
+	flag.Int("idletimeout", defaults.IdleTimeout, "seconds prom exporter keeps idle connections open, 0 for no limit")
+	flag.Int("readywindow", defaults.ReadyWindow, "seconds without conntrack events before /readyz reports not ready")
+	flag.Bool("debug", defaults.Debug, "enabling debugging")
+	flag.Bool("statsout", defaults.StatsOut, "output stats updates to stdout")
+	flag.String("sslcert", defaults.SSLCert, "path to SSL cert to use for prom exporter")
+	flag.String("sslkey", defaults.SSLKey, "path to SSL priv key to use for prom exporter")
+	flag.Bool("usessl", defaults.UseSSL, "set to use HTTP and not HTTPS for Prom exporter")
+	flag.String("webconfig", defaults.WebConfigFile, "exporter-toolkit web config file with TLS, client certificate and basic auth settings for the Prom exporter")
+	flag.Bool("pyroscope", defaults.PyroScope, "sent application metrics to remote pyroschope host")
+	flag.String("pyroscopehost", defaults.PyroScopeHost, "remote pyroscope host to uset")
+	flag.String("pidfile", defaults.PidFile, "pid file to use, locked while running, empty to disable")
+	flag.String("source", defaults.Source, "conntrack event source to use: conntrack, netlink or replay")
+	flag.String("replayfile", defaults.ReplayFile, "captured conntrack -E -o timestamp,id log to replay")
+	flag.Float64("replayspeed", defaults.ReplaySpeed, "replay speed multiplier, 0 replays as fast as possible")
+	flag.Int("netlinkbuffer", defaults.NetlinkBuffer, "bytes of netlink socket buffer for conntrack events, raise it if events are lost")
+	flag.Int("handshaketimeout", defaults.HandshakeTTL, "seconds to wait for an ESTABLISHED before dropping a SYN_RECV")
+	flag.Int("maxpending", defaults.MaxPending, "maximum number of SYN_RECV events waiting for an ESTABLISHED")
+	flag.String("quantiles", defaultQuantiles, "comma separated RTT quantiles to export")
+	flag.Int("quantilewindow", defaults.QuantileWin, "seconds of flows the exported quantiles cover")
+	flag.Bool("devicemetrics", defaults.DeviceMetrics, "export per device RTT series")
+	flag.Int("maxdevices", defaults.MaxDevices, "maximum number of devices with their own series, the rest are reported as other")
+	flag.String("devicetopby", defaults.DeviceTopBy, "rank devices for per device series by flows or rtt")
+	flag.String("subnets", "", "comma separated name=cidr subnets to monitor instead of -network, e.g. guest=192.168.10.0/24")
+
+	config := flag.String("loadconfig", "none", "load json, yaml or toml config file, flags set on the command line override it")
+	configFormat := flag.String("configformat", "", "format of the config file: json, yaml or toml, by default taken from the file extension")
+	checkConfig := flag.Bool("checkconfig", false, "validate the configuration and exit without starting capture")
+	printConfig := flag.Bool("printconfig", false, "print the effective configuration and where each value came from, then exit")
 
 	flag.Parse()
 
-	// check if SSL args needed for Prom. Exporter exist
+	// only flags given on the command line override the lower layers
+	flagValues := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		if key, present := flagKeys[f.Name]; present {
+			flagValues[key] = f.Value.String()
+		}
+	})
 
+	configFile := ""
 	if *config != "none" {
-		fmt.Printf("loading JSON config: %s\n", *config)
-		LoadConfig(*config, arguments)
-	} else {
-		if (*sslCert == "" || *sslKey == "") && *useSsl {
-			fmt.Printf("SSL options missing. Exiting\n")
-			os.Exit(1)
+		fmt.Printf("loading config: %s\n", *config)
+		configFile = *config
+	}
 
-		}
-		arguments.Network = *network
-		arguments.Subnet = *subnet
-		arguments.RunContinuous = *runContinuous
-		arguments.BufferSize = *bufferSize
-		arguments.StatsPeriod = *statsPeriod
-		arguments.PollTime = *pollTime
-		arguments.PromPort = *promPort
-		arguments.Debug = *debug
-		arguments.StatsOut = *statsOut
-		arguments.SSLCert = *sslCert
-		arguments.SSLKey = *sslKey
-		arguments.UseSSL = *useSsl
-		arguments.PyroScope = *pyroscope
-		arguments.PyroScopeHost = *pyroscopeHost
-		arguments.PidFile = *pidFile
-
-		fmt.Printf("loading cli arguments:\n")
+	layered, origins, err := loadLayers(configFile, *configFormat, flagValues)
+	if err != nil {
+		fmt.Printf("%v. Exiting\n", err)
+		os.Exit(ExitFailure)
+	}
+	*arguments = *layered
+
+	if *printConfig {
+		PrintConfig(arguments, origins)
+	}
 
+	// all problems are reported at once rather than one per attempted start
+	if err := arguments.Validate(); err != nil {
+		fmt.Printf("invalid configuration:\n%v\nExiting\n", err)
+		os.Exit(ExitFailure)
+	}
+
+	if *checkConfig || *printConfig {
+		fmt.Printf("configuration ok\n")
+		os.Exit(ExitOK)
 	}
 
 	if arguments.PyroScope {
@@ -89,18 +196,59 @@ func ArgParse(arguments *Args) {
 	}
 }
 
-func LoadConfig(configFile string, arguments *Args) {
+// LoadConfig reads a JSON, YAML or TOML config file over arguments and returns the keys it set. format
+// overrides the file extension when set. Unknown keys are rejected so typos are not silently ignored
+func LoadConfig(configFile, format string, arguments *Args) ([]string, error) {
 
-	jsonFile, err := os.Open(configFile)
+	format, err := configFormat(configFile, format)
+	if err != nil {
+		return nil, err
+	}
 
+	configReader, err := os.Open(configFile)
+
+	if err != nil {
+		return nil, err
+	}
+	defer configReader.Close()
+
+	byteValue, err := io.ReadAll(configReader)
 	if err != nil {
-		fmt.Println(err)
-		CleanUp(arguments.PidFile)
+		return nil, fmt.Errorf("error reading %s: %v", configFile, err)
+	}
 
+	settings, err := decodeConfig(byteValue, format)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", configFile, err)
 	}
 
-	defer jsonFile.Close()
-	byteValue, _ := io.ReadAll(jsonFile)
-	json.Unmarshal([]byte(byteValue), &arguments)
+	// every format is decoded the same way, through the JSON schema of Args
+	jsonValue, err := json.Marshal(settings)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", configFile, err)
+	}
 
+	decoder := json.NewDecoder(bytes.NewReader(jsonValue))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(arguments); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", configFile, err)
+	}
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func parseQuantiles(quantileList string) ([]float64, error) {
+	quantiles := make([]float64, 0, 4)
+	for _, field := range strings.Split(quantileList, ",") {
+		quantile, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || quantile <= 0 || quantile > 1 {
+			return nil, errors.New("invalid quantile: " + field)
+		}
+		quantiles = append(quantiles, quantile)
+	}
+	return quantiles, nil
 }
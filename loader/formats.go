@@ -0,0 +1,113 @@
+package loader
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configSections lets related settings be grouped in the config file, e.g. promport under exporter.
+// Grouped and top level keys are the same settings, a key can only be given once
+var configSections = map[string][]string{
+	"exporter": {"promport", "listen", "metricspath", "readtimeout", "writetimeout", "idletimeout", "readywindow", "usessl", "sslcert", "sslkey", "webconfig", "tls_server_config", "basic_auth_users", "histograms"},
+	"sources":  {"source", "network", "subnetmask", "subnets", "rules", "replayfile", "replayspeed", "netlinkbuffer", "handshaketimeout", "maxpending"},
+	"sinks":    {"statsout", "debug", "pyroscope", "pyroscopehost"},
+}
+
+// configFormat picks the format from override if set, otherwise from the file extension
+func configFormat(configFile, override string) (string, error) {
+	format := override
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(configFile)), ".")
+	}
+
+	switch format {
+	case "json":
+		return "json", nil
+	case "yaml", "yml":
+		return "yaml", nil
+	case "toml":
+		return "toml", nil
+	default:
+		return "", fmt.Errorf("unknown config format %q, use json, yaml or toml", format)
+	}
+}
+
+// decodeConfig reads a config file of any format into flat settings keyed like the JSON config
+func decodeConfig(byteValue []byte, format string) (map[string]interface{}, error) {
+	settings := make(map[string]interface{})
+
+	switch format {
+	case "json":
+		decoder := json.NewDecoder(bytes.NewReader(byteValue))
+		decoder.UseNumber()
+		if err := decoder.Decode(&settings); err != nil {
+			return nil, err
+		}
+	case "yaml":
+		if err := yaml.Unmarshal(byteValue, &settings); err != nil {
+			return nil, err
+		}
+	case "toml":
+		if _, err := toml.Decode(string(byteValue), &settings); err != nil {
+			return nil, err
+		}
+	}
+
+	return flattenSections(settings)
+}
+
+func flattenSections(settings map[string]interface{}) (map[string]interface{}, error) {
+	flattened := make(map[string]interface{}, len(settings))
+	for key, value := range settings {
+		if _, section := configSections[key]; !section {
+			flattened[key] = value
+		}
+	}
+
+	sections := make([]string, 0, len(configSections))
+	for section := range configSections {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+
+	for _, section := range sections {
+		value, present := settings[section]
+		if !present {
+			continue
+		}
+
+		values, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, errors.New(section + " must be a section of settings")
+		}
+
+		for key, setting := range values {
+			if !sectionHas(section, key) {
+				return nil, fmt.Errorf("%s is not a %s setting", key, section)
+			}
+			if _, present := flattened[key]; present {
+				return nil, fmt.Errorf("%s is set both at the top level and in %s", key, section)
+			}
+			flattened[key] = setting
+		}
+	}
+
+	return flattened, nil
+}
+
+func sectionHas(section, key string) bool {
+	for _, sectionKey := range configSections[section] {
+		if sectionKey == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadConfigFormats(t *testing.T) {
+	expected := &Args{
+		Network:     "192.168.0.0/24",
+		BufferSize:  100,
+		PromPort:    "9100",
+		StatsOut:    true,
+		Quantiles:   []float64{0.5, 0.99},
+		Subnets:     []Subnet{{Name: "guest", Network: "192.168.10.0/24"}},
+		DeviceNames: map[string]string{"192.168.0.10": "printer"},
+	}
+
+	testCases := []struct {
+		name   string
+		file   string
+		format string
+		config string
+	}{
+		{
+			name: "JSON",
+			file: "lan-rtt.json",
+			config: `{"network": "192.168.0.0/24", "buffersize": 100, "quantiles": [0.5, 0.99], "exporter": {"promport": "9100"},
+				"sinks": {"statsout": true}, "subnets": [{"name": "guest", "network": "192.168.10.0/24"}], "devicenames": {"192.168.0.10": "printer"}}`,
+		},
+		{
+			name: "YAML",
+			file: "lan-rtt.yml",
+			config: `# annotated
+buffersize: 100
+quantiles: [0.5, 0.99]
+devicenames:
+  192.168.0.10: printer
+sources:
+  network: 192.168.0.0/24
+  subnets:
+    - name: guest
+      network: 192.168.10.0/24
+exporter:
+  promport: "9100"
+sinks:
+  statsout: true
+`,
+		},
+		{
+			name: "TOML",
+			file: "lan-rtt.toml",
+			config: `# annotated
+buffersize = 100
+quantiles = [0.5, 0.99]
+
+[devicenames]
+"192.168.0.10" = "printer"
+
+[sources]
+network = "192.168.0.0/24"
+subnets = [{name = "guest", network = "192.168.10.0/24"}]
+
+[exporter]
+promport = "9100"
+
+[sinks]
+statsout = true
+`,
+		},
+		{
+			name:   "FormatOverridesExtension",
+			file:   "lan-rtt.conf",
+			format: "yaml",
+			config: `{network: 192.168.0.0/24, buffersize: 100, quantiles: [0.5, 0.99], promport: "9100", statsout: true,
+				subnets: [{name: guest, network: 192.168.10.0/24}], devicenames: {192.168.0.10: printer}}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			configFile := filepath.Join(t.TempDir(), tc.file)
+			if err := os.WriteFile(configFile, []byte(tc.config), 0644); err != nil {
+				t.Fatalf("unable to write config: %v", err)
+			}
+
+			arguments := new(Args)
+			if _, err := LoadConfig(configFile, tc.format, arguments); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(arguments, expected) {
+				t.Errorf("Expected %+v, got %+v", expected, arguments)
+			}
+		})
+	}
+}
+
+func TestLoadConfigSectionErrors(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config string
+	}{
+		{name: "WrongSection", config: "exporter:\n  network: 10.0.0.0/8\n"},
+		{name: "SetTwice", config: "promport: \"9100\"\nexporter:\n  promport: \"9200\"\n"},
+		{name: "NotASection", config: "sinks: true\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			configFile := filepath.Join(t.TempDir(), "lan-rtt.yaml")
+			if err := os.WriteFile(configFile, []byte(tc.config), 0644); err != nil {
+				t.Fatalf("unable to write config: %v", err)
+			}
+
+			if _, err := LoadConfig(configFile, "", new(Args)); err == nil {
+				t.Errorf("Expected an error, got none")
+			}
+		})
+	}
+}
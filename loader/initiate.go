@@ -2,31 +2,68 @@ package loader
 
 import (
 	"conntrack-lanrtt-analysis/exporter"
+	"net/http"
 	"os"
 	"runtime"
+	"time"
 
 	"github.com/grafana/pyroscope-go"
 )
 
-func Startup() (*Args, *exporter.PromMetrics) {
+// Startup parses the settings, takes the PID file and starts the exporter. It exits on invalid settings,
+// an exporter that cannot start is returned as an error with the PID file still held
+func Startup() (*Args, *exporter.PromMetrics, *http.Server, error) {
 
 	arguments := new(Args)
 	ArgParse(arguments)
 
 	implementPID(arguments.PidFile)
 
+	// already checked by Validate
+	web, _ := arguments.webConfig()
+	var listen []exporter.ListenAddress
+	if arguments.Listen != "" {
+		listen, _ = exporter.ParseListenAddresses(arguments.Listen)
+	}
+
+	// ready while the event source runs and has delivered an event within the ready window
+	readiness := exporter.NewReadiness(time.Duration(arguments.ReadyWindow) * time.Second)
+
 	exporterOpts := exporter.ExporterOpts{
-		Port:    arguments.PromPort,
-		UseSSL:  arguments.UseSSL,
-		SSLCert: arguments.SSLCert,
-		SSLKey:  arguments.SSLKey,
+		Port:         arguments.PromPort,
+		Listen:       listen,
+		MetricsPath:  arguments.MetricsPath,
+		ReadTimeout:  time.Duration(arguments.ReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(arguments.WriteTimeout) * time.Second,
+		IdleTimeout:  time.Duration(arguments.IdleTimeout) * time.Second,
+		UseSSL:       arguments.UseSSL,
+		SSLCert:      arguments.SSLCert,
+		SSLKey:       arguments.SSLKey,
+		Web:          web,
+		Readiness:    readiness,
+	}
+
+	promReg, promServer, err := exporter.StartPromEndPoint(exporterOpts)
+	if err != nil {
+		return arguments, nil, nil, err
 	}
+	promMetrics := exporter.BuildPromMetricsWithHistograms(promReg, arguments.Histograms)
+	promMetrics.Readiness = readiness
 
-	promReg := exporter.StartPromEndPoint(exporterOpts)
-	promMetrics := exporter.BuildPromMetrics(promReg)
+	return arguments, promMetrics, promServer, nil
 
-	return arguments, promMetrics
+}
 
+// webConfig is the exporter TLS and basic auth setup, read from the webconfig file or taken from the
+// exporter section. nil leaves the exporter to usessl, sslcert and sslkey
+func (arguments *Args) webConfig() (*exporter.WebConfig, error) {
+	if arguments.WebConfigFile != "" {
+		return exporter.LoadWebConfig(arguments.WebConfigFile)
+	}
+	if arguments.TLSServerConfig == nil && len(arguments.BasicAuthUsers) == 0 {
+		return nil, nil
+	}
+	return &exporter.WebConfig{TLSServerConfig: arguments.TLSServerConfig, BasicAuthUsers: arguments.BasicAuthUsers}, nil
 }
 
 func StartPyroScope(arguments *Args) {
@@ -0,0 +1,205 @@
+package loader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const envPrefix = "LANRTT_"
+
+// where a setting's effective value came from, lowest precedence first
+const (
+	originDefault = "default"
+	originFile    = "file"
+	originEnv     = "env"
+	originFlag    = "flag"
+)
+
+// flagKeys maps each command line flag to the config key it sets, most share the name
+var flagKeys = map[string]string{
+	"network":          "network",
+	"mask":             "subnetmask",
+	"continuous":       "runcontinuous",
+	"buffersize":       "buffersize",
+	"statsperiod":      "statsperiod",
+	"pollingtime":      "pollingtime",
+	"promport":         "promport",
+	"listen":           "listen",
+	"metricspath":      "metricspath",
+	"readtimeout":      "readtimeout",
+	"writetimeout":     "writetimeout",
+	"idletimeout":      "idletimeout",
+	"readywindow":      "readywindow",
+	"debug":            "debug",
+	"statsout":         "statsout",
+	"sslcert":          "sslcert",
+	"sslkey":           "sslkey",
+	"usessl":           "usessl",
+	"webconfig":        "webconfig",
+	"pyroscope":        "pyroscope",
+	"pyroscopehost":    "pyroscopehost",
+	"pidfile":          "pidfile",
+	"source":           "source",
+	"replayfile":       "replayfile",
+	"replayspeed":      "replayspeed",
+	"netlinkbuffer":    "netlinkbuffer",
+	"handshaketimeout": "handshaketimeout",
+	"maxpending":       "maxpending",
+	"quantiles":        "quantiles",
+	"quantilewindow":   "quantilewindow",
+	"devicemetrics":    "devicemetrics",
+	"maxdevices":       "maxdevices",
+	"devicetopby":      "devicetopby",
+	"subnets":          "subnets",
+}
+
+// loadLayers merges the built-in defaults, configFile (if any), LANRTT_* environment variables and
+// flagValues, each overriding the one before. origins records the layer every key came from
+func loadLayers(configFile, configFormat string, flagValues map[string]string) (*Args, map[string]string, error) {
+
+	arguments := defaultArgs()
+	origins := make(map[string]string)
+	for _, key := range configKeys() {
+		origins[key] = originDefault
+	}
+
+	if configFile != "" {
+		keys, err := LoadConfig(configFile, configFormat, arguments)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, key := range keys {
+			origins[strings.ToLower(key)] = originFile
+		}
+		arguments.ConfigFile = configFile
+		arguments.ConfigFormat = configFormat
+	}
+
+	for _, key := range configKeys() {
+		value, present := os.LookupEnv(envPrefix + strings.ToUpper(key))
+		if !present {
+			continue
+		}
+		if err := setConfigValue(arguments, key, value); err != nil {
+			return nil, nil, fmt.Errorf("%s%s: %v", envPrefix, strings.ToUpper(key), err)
+		}
+		origins[key] = originEnv
+	}
+
+	for key, value := range flagValues {
+		if err := setConfigValue(arguments, key, value); err != nil {
+			return nil, nil, fmt.Errorf("-%s: %v", key, err)
+		}
+		origins[key] = originFlag
+	}
+	arguments.flagValues = flagValues
+
+	return arguments, origins, nil
+}
+
+// configKeys lists the config file keys in the order the settings are declared in Args
+func configKeys() []string {
+	argsType := reflect.TypeOf(Args{})
+	keys := make([]string, 0, argsType.NumField())
+	for i := 0; i < argsType.NumField(); i++ {
+		if key := configKey(argsType.Field(i)); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func configKey(field reflect.StructField) string {
+	key := strings.Split(field.Tag.Get("json"), ",")[0]
+	if key == "-" {
+		return ""
+	}
+	return key
+}
+
+// setConfigValue sets the setting for key from its text form. Lists use the same comma separated
+// form as their flags, and settings without a flag (rules, devicenames) are given as JSON
+func setConfigValue(arguments *Args, key, value string) error {
+	switch key {
+	case "quantiles":
+		quantiles, err := parseQuantiles(value)
+		if err != nil {
+			return err
+		}
+		arguments.Quantiles = quantiles
+		return nil
+	case "subnets":
+		subnets, err := parseSubnetList(value)
+		if err != nil {
+			return err
+		}
+		arguments.Subnets = subnets
+		return nil
+	}
+
+	argsValue := reflect.ValueOf(arguments).Elem()
+	for i := 0; i < argsValue.NumField(); i++ {
+		if configKey(argsValue.Type().Field(i)) != key {
+			continue
+		}
+
+		field := argsValue.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Bool:
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid value %q", value)
+			}
+			field.SetBool(parsed)
+		case reflect.Int, reflect.Int64:
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid value %q", value)
+			}
+			field.SetInt(parsed)
+		case reflect.Float64:
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("invalid value %q", value)
+			}
+			field.SetFloat(parsed)
+		default:
+			if err := json.Unmarshal([]byte(value), field.Addr().Interface()); err != nil {
+				return fmt.Errorf("invalid value %q: %v", value, err)
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown setting %s", key)
+}
+
+// PrintConfig prints each effective setting with the layer it came from
+func PrintConfig(arguments *Args, origins map[string]string) {
+	fmt.Printf("effective configuration:\n")
+
+	argsValue := reflect.ValueOf(arguments).Elem()
+	for i := 0; i < argsValue.NumField(); i++ {
+		key := configKey(argsValue.Type().Field(i))
+		if key == "" {
+			continue
+		}
+
+		var value string
+		switch field := argsValue.Field(i); field.Kind() {
+		case reflect.Slice, reflect.Map, reflect.Ptr:
+			encoded, _ := json.Marshal(field.Interface())
+			value = string(encoded)
+		default:
+			value = fmt.Sprint(field.Interface())
+		}
+
+		fmt.Printf("  %s = %s (%s)\n", key, value, origins[key])
+	}
+}
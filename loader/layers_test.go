@@ -0,0 +1,60 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadLayers(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "lan-rtt.json")
+	config := `{"network": "192.168.0.0/24", "buffersize": 100, "statsperiod": 10, "promport": "9100"}`
+	if err := os.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("unable to write config: %v", err)
+	}
+
+	os.Setenv("LANRTT_STATSPERIOD", "20")
+	os.Setenv("LANRTT_PROMPORT", "9200")
+	defer os.Unsetenv("LANRTT_STATSPERIOD")
+	defer os.Unsetenv("LANRTT_PROMPORT")
+
+	flagValues := map[string]string{"promport": "9300", "quantiles": "0.5,0.99"}
+
+	arguments, origins, err := loadLayers(configFile, "", flagValues)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	testCases := []struct {
+		key            string
+		value          interface{}
+		expected       interface{}
+		expectedOrigin string
+	}{
+		{key: "pollingtime", value: arguments.PollTime, expected: int64(300), expectedOrigin: originDefault},
+		{key: "buffersize", value: arguments.BufferSize, expected: 100, expectedOrigin: originFile},
+		{key: "statsperiod", value: arguments.StatsPeriod, expected: 20, expectedOrigin: originEnv},
+		{key: "promport", value: arguments.PromPort, expected: "9300", expectedOrigin: originFlag},
+		{key: "quantiles", value: len(arguments.Quantiles), expected: 2, expectedOrigin: originFlag},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.key, func(t *testing.T) {
+			if tc.value != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, tc.value)
+			}
+			if origins[tc.key] != tc.expectedOrigin {
+				t.Errorf("Expected origin %s, got %s", tc.expectedOrigin, origins[tc.key])
+			}
+		})
+	}
+}
+
+func TestLoadLayersInvalidEnv(t *testing.T) {
+	os.Setenv("LANRTT_BUFFERSIZE", "lots")
+	defer os.Unsetenv("LANRTT_BUFFERSIZE")
+
+	if _, _, err := loadLayers("", "", nil); err == nil {
+		t.Errorf("Expected an error for an invalid environment value, got none")
+	}
+}
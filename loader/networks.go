@@ -0,0 +1,39 @@
+package loader
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+// ParseNetworks reads a comma separated list of networks in CIDR notation for either family.
+// A bare address is combined with the dotted mask for compatibility with older configs
+func ParseNetworks(networkList, mask string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, 2)
+
+	for _, field := range strings.Split(networkList, ",") {
+		field = strings.TrimSpace(field)
+
+		if strings.Contains(field, "/") {
+			_, network, err := net.ParseCIDR(field)
+			if err != nil {
+				return nil, errors.New("invalid network: " + field)
+			}
+			networks = append(networks, network)
+			continue
+		}
+
+		ip := net.ParseIP(field).To4()
+		if ip == nil {
+			return nil, errors.New("invalid network address: " + field)
+		}
+		maskIP := net.ParseIP(mask).To4()
+		if maskIP == nil {
+			return nil, errors.New("invalid subnet mask: " + mask)
+		}
+		ipMask := net.IPMask(maskIP)
+		networks = append(networks, &net.IPNet{IP: ip.Mask(ipMask), Mask: ipMask})
+	}
+
+	return networks, nil
+}
@@ -0,0 +1,66 @@
+package loader
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseNetworks(t *testing.T) {
+	testCases := []struct {
+		name             string
+		networks         string
+		mask             string
+		expectedNetworks []string
+		expectError      bool
+	}{
+		{
+			name:             "LegacyAddressAndMask",
+			networks:         "192.168.0.0",
+			mask:             "255.255.255.0",
+			expectedNetworks: []string{"192.168.0.0/24"},
+		},
+		{
+			name:             "IPv4CIDR",
+			networks:         "10.152.0.0/20",
+			expectedNetworks: []string{"10.152.0.0/20"},
+		},
+		{
+			name:             "IPv6CIDR",
+			networks:         "2001:db8::/56",
+			expectedNetworks: []string{"2001:db8::/56"},
+		},
+		{
+			name:             "DualStack",
+			networks:         "10.152.0.0/20, 2001:db8::/56",
+			expectedNetworks: []string{"10.152.0.0/20", "2001:db8::/56"},
+		},
+		{
+			name:        "Invalid",
+			networks:    "2001:db8::/200",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			networks, err := ParseNetworks(tc.networks, tc.mask)
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("Test %s: expected error", tc.name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Test %s: unexpected error %v", tc.name, err)
+			}
+
+			parsed := make([]string, 0, len(networks))
+			for _, network := range networks {
+				parsed = append(parsed, network.String())
+			}
+			if strings.Join(parsed, ",") != strings.Join(tc.expectedNetworks, ",") {
+				t.Errorf("Test %s: expected %v, got %v", tc.name, tc.expectedNetworks, parsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package loader
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	if err := Notify("READY=1"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("unable to read notification: %v", err)
+	}
+	if string(buf[:n]) != "READY=1" {
+		t.Errorf("Expected READY=1, got %q", buf[:n])
+	}
+}
+
+func TestNotifyDisabled(t *testing.T) {
+	os.Unsetenv("NOTIFY_SOCKET")
+	if err := Notify("READY=1"); err != nil {
+		t.Errorf("Expected no error without NOTIFY_SOCKET, got %v", err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	testCases := []struct {
+		name     string
+		usec     string
+		pid      string
+		expected time.Duration
+	}{
+		{name: "Disabled", expected: 0},
+		{name: "Enabled", usec: "30000000", expected: 30 * time.Second},
+		{name: "OurPID", usec: "30000000", pid: strconv.Itoa(os.Getpid()), expected: 30 * time.Second},
+		{name: "OtherPID", usec: "30000000", pid: "1", expected: 0},
+		{name: "Invalid", usec: "soon", expected: 0},
+	}
+
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			os.Setenv("WATCHDOG_USEC", tc.usec)
+			os.Setenv("WATCHDOG_PID", tc.pid)
+
+			if interval := WatchdogInterval(); interval != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, interval)
+			}
+		})
+	}
+}
@@ -1,59 +1,115 @@
 package loader
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"syscall"
 )
 
+// exit codes distinguishing a requested stop from a failure
+const (
+	ExitOK      = 0
+	ExitFailure = 1
+)
+
+// pidLock is the open PID file, its exclusive lock is held until RemovePID
+var pidLock *os.File
+
 func implementPID(pidFile string) {
-	if checkPID(pidFile) {
-		fmt.Printf("Another instance of lanRTT is already running. Exiting.\n")
-		os.Exit(1)
+	if err := lockPID(pidFile); err != nil {
+		fmt.Printf("%v. Exiting.\n", err)
+		os.Exit(ExitFailure)
 	}
+}
 
-	err := writePID(pidFile)
-	if err != nil {
-		fmt.Printf("Unable to write PID file: %s\n", err)
-		os.Exit(1)
+// lockPID takes an exclusive lock on pidFile and writes our PID to it. A file left behind by an
+// instance that is no longer running is not locked, so it is simply taken over. An empty pidFile
+// disables the PID file, e.g. under systemd
+func lockPID(pidFile string) error {
+	if pidFile == "" {
+		return nil
 	}
 
-}
+	for {
+		file, err := os.OpenFile(pidFile, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return fmt.Errorf("unable to open PID file: %v", err)
+		}
 
-func writePID(pidFile string) error {
-	pid := []byte(strconv.Itoa(os.Getpid()) + "\n")
-	return os.WriteFile(pidFile, pid, 0644)
+		if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			file.Close()
+			if err == syscall.EWOULDBLOCK {
+				if pid, err := readPID(pidFile); err == nil {
+					return fmt.Errorf("another instance of lanRTT is already running with PID %d", pid)
+				}
+				return errors.New("another instance of lanRTT is already running")
+			}
+			return fmt.Errorf("unable to lock PID file: %v", err)
+		}
+
+		// the previous owner may have removed the file between our open and lock, in which case
+		// the lock is on a file nobody else can see and we try again with a fresh one
+		if !samePIDFile(file, pidFile) {
+			file.Close()
+			continue
+		}
+
+		if err := writePID(file); err != nil {
+			file.Close()
+			return fmt.Errorf("unable to write PID file: %v", err)
+		}
+
+		pidLock = file
+		return nil
+	}
 }
 
-func checkPID(pidFile string) bool {
-	pidData, err := os.ReadFile(pidFile)
+func samePIDFile(file *os.File, pidFile string) bool {
+	opened, err := file.Stat()
 	if err != nil {
 		return false
 	}
-
-	pid, err := strconv.Atoi(string(pidData))
+	current, err := os.Stat(pidFile)
 	if err != nil {
-		fmt.Printf("Invalid PID in PID file: %s\n", pidData)
-		os.Exit(1)
 		return false
 	}
+	return os.SameFile(opened, current)
+}
 
-	process, err := os.FindProcess(pid)
-	if err != nil {
-		return false
+func writePID(file *os.File) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	pid := []byte(strconv.Itoa(os.Getpid()) + "\n")
+	if _, err := file.WriteAt(pid, 0); err != nil {
+		return err
 	}
+	return file.Sync()
+}
 
-	err = process.Signal(syscall.Signal(0))
-	return err == nil
+func readPID(pidFile string) (int, error) {
+	pidData, err := os.ReadFile(pidFile)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(pidData)))
 }
 
-func CleanUp(pidFile string) {
+// RemovePID removes the PID file and releases its lock, it does nothing unless we hold the lock
+func RemovePID(pidFile string) {
+	if pidLock == nil {
+		return
+	}
 
+	// remove before unlocking so a new instance never locks a file that is about to disappear
 	err := os.Remove(pidFile)
 	if err != nil {
 		fmt.Printf("error removing PID file: %v\n", err)
 	}
-	fmt.Println("exiting...")
-	os.Exit(1)
+
+	pidLock.Close()
+	pidLock = nil
 }
@@ -0,0 +1,78 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLockPID(t *testing.T) {
+	testCases := []struct {
+		name     string
+		existing string
+	}{
+		{name: "NoFile"},
+		{name: "StaleWithNewline", existing: "999999\n"},
+		{name: "StaleReusedPID", existing: "1\n"},
+		{name: "Garbage", existing: "not a pid"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pidFile := filepath.Join(t.TempDir(), "lanrtt.pid")
+			if tc.existing != "" {
+				if err := os.WriteFile(pidFile, []byte(tc.existing), 0644); err != nil {
+					t.Fatalf("unable to write PID file: %v", err)
+				}
+			}
+
+			if err := lockPID(pidFile); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			defer RemovePID(pidFile)
+
+			pidData, _ := os.ReadFile(pidFile)
+			if strings.TrimSpace(string(pidData)) != strconv.Itoa(os.Getpid()) {
+				t.Errorf("Expected PID file to contain %d, got %q", os.Getpid(), pidData)
+			}
+		})
+	}
+}
+
+func TestLockPIDHeld(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "lanrtt.pid")
+
+	if err := lockPID(pidFile); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	held := pidLock
+
+	// a second instance opens the file separately and must not get the lock
+	pidLock = nil
+	if err := lockPID(pidFile); err == nil || !strings.Contains(err.Error(), strconv.Itoa(os.Getpid())) {
+		t.Errorf("Expected already running with PID %d, got %v", os.Getpid(), err)
+	}
+
+	pidLock = held
+	RemovePID(pidFile)
+	if _, err := os.Stat(pidFile); !os.IsNotExist(err) {
+		t.Errorf("Expected PID file to be removed, got %v", err)
+	}
+
+	// once released the next instance can start
+	if err := lockPID(pidFile); err != nil {
+		t.Errorf("unexpected error after release %v", err)
+	}
+	RemovePID(pidFile)
+}
+
+func TestLockPIDDisabled(t *testing.T) {
+	if err := lockPID(""); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if pidLock != nil {
+		t.Errorf("Expected no PID file to be held")
+	}
+}
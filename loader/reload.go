@@ -0,0 +1,76 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// settings only used at startup, by what they configure
+var (
+	exporterKeys = configSections["exporter"]
+	processKeys  = []string{"pidfile", "pyroscope", "pyroscopehost"}
+	runTimeKeys  = []string{"runcontinuous", "pollingtime"}
+)
+
+// ReloadConfig re-reads the config file current was loaded from, with the environment and flags
+// layered over it as at startup. Settings that are only used at
+// startup (exporter, PID file, pyroscope and run time) keep their current values
+func ReloadConfig(current *Args) (*Args, error) {
+
+	if current.ConfigFile == "" {
+		return nil, errors.New("not started with -loadconfig, nothing to reload")
+	}
+
+	arguments, _, err := loadLayers(current.ConfigFile, current.ConfigFormat, current.flagValues)
+	if err != nil {
+		return nil, err
+	}
+
+	if keepSettings(arguments, current, exporterKeys) {
+		fmt.Printf("exporter settings changed, restart to apply them\n")
+	}
+	if keepSettings(arguments, current, processKeys) {
+		fmt.Printf("pidfile and pyroscope settings changed, restart to apply them\n")
+	}
+	if keepSettings(arguments, current, runTimeKeys) {
+		fmt.Printf("runcontinuous and pollingtime changed, restart to apply them\n")
+	}
+	arguments.ConfigFile = current.ConfigFile
+
+	if err := arguments.Validate(); err != nil {
+		return nil, err
+	}
+
+	return arguments, nil
+}
+
+// keepSettings puts back the current values of keys in arguments and reports whether any had changed
+func keepSettings(arguments, current *Args, keys []string) bool {
+	changed := false
+
+	argsValue := reflect.ValueOf(arguments).Elem()
+	currentValue := reflect.ValueOf(current).Elem()
+	for i := 0; i < argsValue.NumField(); i++ {
+		key := configKey(argsValue.Type().Field(i))
+		if key == "" || !containsKey(keys, key) {
+			continue
+		}
+
+		if !reflect.DeepEqual(argsValue.Field(i).Interface(), currentValue.Field(i).Interface()) {
+			changed = true
+		}
+		argsValue.Field(i).Set(currentValue.Field(i))
+	}
+
+	return changed
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, candidate := range keys {
+		if candidate == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadConfig(t *testing.T) {
+	testCases := []struct {
+		name        string
+		config      string
+		expectError bool
+	}{
+		{
+			name:   "Valid",
+			config: `{"network": "192.168.0.0/24", "buffersize": 50, "statsperiod": 10, "promport": "9100"}`,
+		},
+		{
+			name:        "OverlappingSubnets",
+			config:      `{"buffersize": 50, "statsperiod": 10, "subnets": [{"name": "a", "network": "10.0.0.0/8"}, {"name": "b", "network": "10.1.0.0/16"}]}`,
+			expectError: true,
+		},
+		{
+			name:        "ZeroStatsPeriod",
+			config:      `{"buffersize": 50, "statsperiod": 0}`,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			configFile := filepath.Join(t.TempDir(), "lan-rtt.json")
+			if err := os.WriteFile(configFile, []byte(tc.config), 0644); err != nil {
+				t.Fatalf("unable to write config: %v", err)
+			}
+
+			current := &Args{ConfigFile: configFile, PromPort: "9000", ReadyWindow: 60, RunContinuous: true, BufferSize: 100, StatsPeriod: 5}
+			reloaded, err := ReloadConfig(current)
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("Expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			if reloaded.BufferSize != 50 || reloaded.StatsPeriod != 10 {
+				t.Errorf("Expected buffersize 50 and statsperiod 10, got %v and %v", reloaded.BufferSize, reloaded.StatsPeriod)
+			}
+			// the exporter is only started once, its settings are kept
+			if reloaded.PromPort != "9000" {
+				t.Errorf("Expected promport to stay 9000, got %v", reloaded.PromPort)
+			}
+		})
+	}
+}
+
+func TestReloadConfigWithoutFile(t *testing.T) {
+	if _, err := ReloadConfig(&Args{}); err == nil {
+		t.Errorf("Expected an error without a config file, got none")
+	}
+}
@@ -0,0 +1,85 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Rule allows or denies events before they are recorded. Every field that is set must match,
+// any entry within a field may match. Rules are evaluated in order and the first match wins
+type Rule struct {
+	Name         string   `json:"name"`
+	Action       string   `json:"action"`
+	Sources      []string `json:"sources"`
+	Destinations []string `json:"destinations"`
+	Ports        []int    `json:"dstports"`
+}
+
+type FilterRule struct {
+	Name         string
+	Allow        bool
+	Sources      []*net.IPNet
+	Destinations []*net.IPNet
+	Ports        map[int]bool
+}
+
+// ParseRules parses every rule, rejecting missing or duplicate names, unknown actions, invalid CIDRs and ports
+func ParseRules(rules []Rule) ([]FilterRule, error) {
+	parsed := make([]FilterRule, 0, len(rules))
+	names := make(map[string]bool)
+
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return nil, errors.New("filter rule has no name")
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("filter rule name %s used more than once", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if rule.Action != "allow" && rule.Action != "deny" {
+			return nil, fmt.Errorf("filter rule %s has invalid action %q, expected allow or deny", rule.Name, rule.Action)
+		}
+
+		sources, err := parseCIDRs(rule.Name, rule.Sources)
+		if err != nil {
+			return nil, err
+		}
+
+		destinations, err := parseCIDRs(rule.Name, rule.Destinations)
+		if err != nil {
+			return nil, err
+		}
+
+		ports := make(map[int]bool)
+		for _, port := range rule.Ports {
+			if port < 1 || port > 65535 {
+				return nil, fmt.Errorf("filter rule %s has invalid port %d", rule.Name, port)
+			}
+			ports[port] = true
+		}
+
+		parsed = append(parsed, FilterRule{
+			Name:         rule.Name,
+			Allow:        rule.Action == "allow",
+			Sources:      sources,
+			Destinations: destinations,
+			Ports:        ports,
+		})
+	}
+
+	return parsed, nil
+}
+
+func parseCIDRs(ruleName string, cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("filter rule %s has invalid network %s", ruleName, cidr)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
@@ -0,0 +1,25 @@
+package loader
+
+import (
+	"testing"
+)
+
+func TestParseRulesInvalid(t *testing.T) {
+	testCases := []struct {
+		name string
+		rule Rule
+	}{
+		{name: "MissingName", rule: Rule{Action: "deny"}},
+		{name: "InvalidAction", rule: Rule{Name: "rule", Action: "drop"}},
+		{name: "InvalidSource", rule: Rule{Name: "rule", Action: "deny", Sources: []string{"192.168.0.1"}}},
+		{name: "InvalidPort", rule: Rule{Name: "rule", Action: "deny", Ports: []int{70000}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := ParseRules([]Rule{tc.rule}); err == nil {
+				t.Errorf("Test %s: expected error", tc.name)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Subnet is a named network monitored from the shared event stream, e.g. a guest or IoT VLAN
+type Subnet struct {
+	Name    string `json:"name"`
+	Network string `json:"network"`
+}
+
+type NamedNetwork struct {
+	Name    string
+	Network *net.IPNet
+}
+
+// ParseSubnets parses every subnet, rejecting missing or duplicate names, invalid CIDRs and overlapping ranges
+func ParseSubnets(subnets []Subnet) ([]NamedNetwork, error) {
+	named := make([]NamedNetwork, 0, len(subnets))
+	names := make(map[string]bool)
+
+	for _, subnet := range subnets {
+		if subnet.Name == "" {
+			return nil, fmt.Errorf("subnet %s has no name", subnet.Network)
+		}
+		if names[subnet.Name] {
+			return nil, fmt.Errorf("subnet name %s used more than once", subnet.Name)
+		}
+		names[subnet.Name] = true
+
+		_, network, err := net.ParseCIDR(subnet.Network)
+		if err != nil {
+			return nil, fmt.Errorf("subnet %s has invalid network %s", subnet.Name, subnet.Network)
+		}
+
+		for _, other := range named {
+			if other.Network.Contains(network.IP) || network.Contains(other.Network.IP) {
+				return nil, fmt.Errorf("subnet %s (%s) overlaps subnet %s (%s)", subnet.Name, network, other.Name, other.Network)
+			}
+		}
+
+		named = append(named, NamedNetwork{Name: subnet.Name, Network: network})
+	}
+
+	return named, nil
+}
+
+// parseSubnetList reads the -subnets flag, a comma separated list of name=cidr pairs
+func parseSubnetList(subnetList string) ([]Subnet, error) {
+	subnets := make([]Subnet, 0, 4)
+	if subnetList == "" {
+		return subnets, nil
+	}
+
+	for _, field := range strings.Split(subnetList, ",") {
+		parts := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("invalid subnet, expected name=cidr: " + field)
+		}
+		subnets = append(subnets, Subnet{Name: parts[0], Network: parts[1]})
+	}
+
+	return subnets, nil
+}
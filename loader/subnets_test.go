@@ -0,0 +1,84 @@
+package loader
+
+import (
+	"testing"
+)
+
+func TestParseSubnets(t *testing.T) {
+	testCases := []struct {
+		name        string
+		subnets     []Subnet
+		expectError bool
+	}{
+		{
+			name: "Valid",
+			subnets: []Subnet{
+				{Name: "guest", Network: "192.168.10.0/24"},
+				{Name: "staff", Network: "192.168.20.0/24"},
+				{Name: "iot", Network: "2001:db8:0:30::/64"},
+			},
+		},
+		{
+			name: "Overlapping",
+			subnets: []Subnet{
+				{Name: "staff", Network: "10.0.0.0/16"},
+				{Name: "printers", Network: "10.0.5.0/24"},
+			},
+			expectError: true,
+		},
+		{
+			name: "InvalidNetwork",
+			subnets: []Subnet{
+				{Name: "guest", Network: "192.168.10.0"},
+			},
+			expectError: true,
+		},
+		{
+			name: "DuplicateName",
+			subnets: []Subnet{
+				{Name: "guest", Network: "192.168.10.0/24"},
+				{Name: "guest", Network: "192.168.20.0/24"},
+			},
+			expectError: true,
+		},
+		{
+			name: "MissingName",
+			subnets: []Subnet{
+				{Network: "192.168.10.0/24"},
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			named, err := ParseSubnets(tc.subnets)
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("Test %s: expected error", tc.name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Test %s: unexpected error %v", tc.name, err)
+			}
+			if len(named) != len(tc.subnets) {
+				t.Errorf("Test %s: expected %d subnets, got %d", tc.name, len(tc.subnets), len(named))
+			}
+		})
+	}
+}
+
+func TestParseSubnetList(t *testing.T) {
+	subnets, err := parseSubnetList("guest=192.168.10.0/24, staff=192.168.20.0/24")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(subnets) != 2 || subnets[1].Name != "staff" || subnets[1].Network != "192.168.20.0/24" {
+		t.Errorf("unexpected subnets %+v", subnets)
+	}
+
+	if _, err := parseSubnetList("guest"); err == nil {
+		t.Errorf("expected error for subnet without a network")
+	}
+}
@@ -0,0 +1,180 @@
+package loader
+
+import (
+	"conntrack-lanrtt-analysis/exporter"
+	"errors"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Validate checks every setting and reports all the problems found together, one per line
+func (arguments *Args) Validate() error {
+
+	problems := make([]string, 0)
+	problem := func(message string) {
+		problems = append(problems, message)
+	}
+
+	switch arguments.Source {
+	case "conntrack", "", "netlink":
+		// named subnets replace the network list
+		if len(arguments.Subnets) == 0 {
+			if _, err := ParseNetworks(arguments.Network, arguments.Subnet); err != nil {
+				problem("network/subnetmask: " + err.Error())
+			}
+		}
+	case "replay":
+		if arguments.ReplayFile == "" {
+			problem("replayfile: needed by the replay source")
+		} else if _, err := os.Stat(arguments.ReplayFile); err != nil {
+			problem("replayfile: " + err.Error())
+		}
+	default:
+		problem("source: unknown event source " + strconv.Quote(arguments.Source) + ", use conntrack, netlink or replay")
+	}
+
+	if arguments.BufferSize < 1 {
+		problem("buffersize: must be at least 1")
+	}
+	if arguments.StatsPeriod < 1 {
+		problem("statsperiod: must be at least 1 second")
+	}
+	if !arguments.RunContinuous && arguments.PollTime < 1 {
+		problem("pollingtime: must be at least 1 second unless running continuously")
+	}
+	if arguments.PromPort == "" {
+		problem("promport: missing")
+	}
+	if arguments.Listen != "" {
+		if _, err := exporter.ParseListenAddresses(arguments.Listen); err != nil {
+			problem("listen: " + err.Error())
+		}
+	}
+	switch {
+	case arguments.MetricsPath == "":
+	case !strings.HasPrefix(arguments.MetricsPath, "/"):
+		problem("metricspath: must start with /")
+	case arguments.MetricsPath == "/healthz" || arguments.MetricsPath == "/readyz":
+		problem("metricspath: " + arguments.MetricsPath + " is already used by the exporter")
+	}
+	if arguments.ReadTimeout < 0 || arguments.WriteTimeout < 0 || arguments.IdleTimeout < 0 {
+		problem("readtimeout/writetimeout/idletimeout: must not be negative")
+	}
+	if arguments.ReadyWindow < 1 {
+		problem("readywindow: must be at least 1 second")
+	}
+
+	if arguments.UseSSL {
+		if arguments.SSLCert == "" || arguments.SSLKey == "" {
+			problem("sslcert/sslkey: both are needed with usessl")
+		}
+		for _, file := range []string{arguments.SSLCert, arguments.SSLKey} {
+			if file == "" {
+				continue
+			}
+			if _, err := os.Stat(file); err != nil {
+				problem("sslcert/sslkey: " + err.Error())
+			}
+		}
+	}
+
+	web, err := arguments.webConfig()
+	switch {
+	case err != nil:
+		problem("webconfig: " + err.Error())
+	case arguments.WebConfigFile != "" && (arguments.TLSServerConfig != nil || len(arguments.BasicAuthUsers) > 0):
+		problem("webconfig: tls_server_config and basic_auth_users belong in the web config file when one is used")
+	case web != nil:
+		if arguments.UseSSL && web.TLSServerConfig != nil {
+			problem("usessl: set cert_file and key_file in tls_server_config instead")
+		}
+		if err := web.Validate(); err != nil {
+			problem(err.Error())
+		}
+	}
+
+	if err := exporter.ValidateHistograms(arguments.Histograms); err != nil {
+		problem("histograms: " + err.Error())
+	}
+
+	if arguments.NetlinkBuffer < 1 && arguments.Source != "replay" {
+		problem("netlinkbuffer: must be at least 1 byte")
+	}
+	if arguments.ReplaySpeed < 0 {
+		problem("replayspeed: must not be negative")
+	}
+	if arguments.HandshakeTTL < 1 {
+		problem("handshaketimeout: must be at least 1 second")
+	}
+	if arguments.MaxPending < 1 {
+		problem("maxpending: must be at least 1")
+	}
+	if len(arguments.Quantiles) == 0 {
+		problem("quantiles: at least one quantile is needed")
+	}
+	for _, quantile := range arguments.Quantiles {
+		if quantile <= 0 || quantile > 1 {
+			problem("quantiles: " + strconv.FormatFloat(quantile, 'f', -1, 64) + " is not between 0 and 1")
+		}
+	}
+	if arguments.QuantileWin < 1 {
+		problem("quantilewindow: must be at least 1 second")
+	}
+
+	if arguments.DeviceMetrics {
+		if arguments.MaxDevices < 1 {
+			problem("maxdevices: must be at least 1 with devicemetrics")
+		}
+		if arguments.DeviceTopBy != "flows" && arguments.DeviceTopBy != "rtt" {
+			problem("devicetopby: must be flows or rtt")
+		}
+	}
+	for _, message := range deviceNameProblems(arguments.DeviceNames) {
+		problem("devicenames: " + message)
+	}
+
+	// reject invalid or overlapping subnets before starting capture
+	if _, err := ParseSubnets(arguments.Subnets); err != nil {
+		problem("subnets: " + err.Error())
+	}
+	if _, err := ParseRules(arguments.Rules); err != nil {
+		problem("rules: " + err.Error())
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// deviceNameProblems finds names that would put two devices in one series: a name given twice,
+// the reserved name of the combined series, or another device's address
+func deviceNameProblems(names map[string]string) []string {
+	deviceIPs := make([]string, 0, len(names))
+	for deviceIP := range names {
+		deviceIPs = append(deviceIPs, deviceIP)
+	}
+	sort.Strings(deviceIPs)
+
+	problems := make([]string, 0)
+	namedBy := make(map[string]string, len(names))
+	for _, deviceIP := range deviceIPs {
+		name := names[deviceIP]
+		switch {
+		case name == "":
+			problems = append(problems, deviceIP+" has an empty name")
+		case name == exporter.OtherDevices:
+			problems = append(problems, deviceIP+": "+strconv.Quote(name)+" is reserved for the devices outside the top maxdevices")
+		case net.ParseIP(name) != nil:
+			problems = append(problems, deviceIP+": "+strconv.Quote(name)+" is an address, it would collide with that device")
+		case namedBy[name] != "":
+			problems = append(problems, deviceIP+": "+strconv.Quote(name)+" is already the name of "+namedBy[name])
+		default:
+			namedBy[name] = deviceIP
+		}
+	}
+	return problems
+}
@@ -0,0 +1,155 @@
+package loader
+
+import (
+	"conntrack-lanrtt-analysis/exporter"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	valid := func() *Args {
+		return &Args{
+			Network:       "192.168.0.0/24",
+			BufferSize:    2000,
+			StatsPeriod:   5,
+			PollTime:      300,
+			PromPort:      "1986",
+			ReadyWindow:   defaultReadyWindow,
+			NetlinkBuffer: defaultNetlinkBuf,
+			HandshakeTTL:  defaultHandshakeTTL,
+			MaxPending:    defaultMaxPending,
+			Quantiles:     []float64{0.5, 0.99},
+			QuantileWin:   defaultQuantileWin,
+		}
+	}
+
+	testCases := []struct {
+		name             string
+		modify           func(arguments *Args)
+		expectedProblems []string
+	}{
+		{
+			name:   "Valid",
+			modify: func(arguments *Args) {},
+		},
+		{
+			name: "CollectsEveryProblem",
+			modify: func(arguments *Args) {
+				arguments.Network = "192.168.0.0/33"
+				arguments.BufferSize = 0
+				arguments.StatsPeriod = -1
+				arguments.Quantiles = []float64{1.5}
+			},
+			expectedProblems: []string{"network/subnetmask", "buffersize", "statsperiod", "quantiles"},
+		},
+		{
+			name: "MissingSSLFiles",
+			modify: func(arguments *Args) {
+				arguments.UseSSL = true
+				arguments.SSLCert = "/nonexistent/lanrtt.crt"
+			},
+			expectedProblems: []string{"sslcert/sslkey: both", "sslcert/sslkey: stat"},
+		},
+		{
+			name: "ReplayWithoutFile",
+			modify: func(arguments *Args) {
+				arguments.Source = "replay"
+			},
+			expectedProblems: []string{"replayfile"},
+		},
+		{
+			name: "PlainPasswordHash",
+			modify: func(arguments *Args) {
+				arguments.BasicAuthUsers = map[string]string{"prometheus": "secret"}
+			},
+			expectedProblems: []string{"basic_auth_users: prometheus"},
+		},
+		{
+			name: "TLSWithoutCertificate",
+			modify: func(arguments *Args) {
+				arguments.TLSServerConfig = &exporter.TLSServerConfig{ClientCAFile: "/nonexistent/ca.crt"}
+			},
+			expectedProblems: []string{"tls_server_config: cert_file and key_file"},
+		},
+		{
+			name: "HistogramLayout",
+			modify: func(arguments *Args) {
+				arguments.Histograms = map[string]exporter.HistogramBuckets{"flows": {Layout: "explicit"}}
+			},
+			expectedProblems: []string{"histograms: flows"},
+		},
+		{
+			name: "CollidingDeviceNames",
+			modify: func(arguments *Args) {
+				arguments.DeviceNames = map[string]string{
+					"192.168.0.10": "printer",
+					"192.168.0.11": "printer",
+					"192.168.0.12": "other",
+					"192.168.0.13": "192.168.0.14",
+				}
+			},
+			expectedProblems: []string{"devicenames: 192.168.0.11", "devicenames: 192.168.0.12", "devicenames: 192.168.0.13"},
+		},
+		{
+			name: "MissingWebConfig",
+			modify: func(arguments *Args) {
+				arguments.WebConfigFile = "/nonexistent/web.yml"
+			},
+			expectedProblems: []string{"webconfig: open"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			arguments := valid()
+			tc.modify(arguments)
+
+			err := arguments.Validate()
+			if len(tc.expectedProblems) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Expected problems %v, got none", tc.expectedProblems)
+			}
+
+			problems := strings.Split(err.Error(), "\n")
+			if len(problems) != len(tc.expectedProblems) {
+				t.Errorf("Expected %v problems, got %v: %v", len(tc.expectedProblems), len(problems), problems)
+			}
+			for _, expected := range tc.expectedProblems {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("Expected a %s problem, got %v", expected, problems)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigUnknownKey(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "lan-rtt.json")
+	if err := os.WriteFile(configFile, []byte(`{"network": "192.168.0.0/24", "bufersize": 100}`), 0644); err != nil {
+		t.Fatalf("unable to write config: %v", err)
+	}
+
+	_, err := LoadConfig(configFile, "", new(Args))
+	if err == nil || !strings.Contains(err.Error(), "bufersize") {
+		t.Errorf("Expected the unknown key to be rejected, got %v", err)
+	}
+}
+
+func TestLoadConfigExample(t *testing.T) {
+	for _, configFile := range []string{"../lan-rtt.json", "../lan-rtt.yaml"} {
+		arguments := defaultArgs()
+		if _, err := LoadConfig(configFile, "", arguments); err != nil {
+			t.Fatalf("unexpected error loading %s: %v", configFile, err)
+		}
+		if err := arguments.Validate(); err != nil {
+			t.Errorf("Expected %s to be valid, got %v", configFile, err)
+		}
+	}
+}
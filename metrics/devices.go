@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"conntrack-lanrtt-analysis/exporter"
+	"conntrack-lanrtt-analysis/loader"
+	"fmt"
+	"sort"
+)
+
+type deviceStats struct {
+	device    string
+	flowCount int
+	mean      float64
+}
+
+// CalculateDeviceStats exports per device series for the top MaxDevices devices of the stats period,
+// ranked by flow count or worst mean RTT. The remaining devices are folded into a single "other" series
+// so the number of series stays bounded however many clients are seen
+func CalculateDeviceStats(deviceFlows map[string][]float64, args *loader.Args, promMetrics *exporter.PromMetrics) {
+	// devicemetrics can be turned off by a reload, the last period's devices go with it
+	if !args.DeviceMetrics {
+		promMetrics.Devices.Set(nil)
+		return
+	}
+
+	devices := make([]deviceStats, 0, len(deviceFlows))
+	for deviceIP, rtts := range deviceFlows {
+		devices = append(devices, deviceStats{device: deviceIP, flowCount: len(rtts), mean: CalculateMean(rtts)})
+	}
+
+	rankDevices(devices, args.DeviceTopBy)
+
+	// the period's series replace the last ones in one go, devices dropping out of the top list go with them
+	series := make(map[string]exporter.DeviceSeries, args.MaxDevices+1)
+
+	var otherTotal float64
+	var otherCount int
+
+	for i, device := range devices {
+		if i >= args.MaxDevices {
+			otherTotal += device.mean * float64(device.flowCount)
+			otherCount += device.flowCount
+			continue
+		}
+
+		name := deviceName(device.device, args.DeviceNames)
+		series[name] = exporter.DeviceSeries{Mean: device.mean, FlowCount: float64(device.flowCount)}
+		logDeviceStats(args, name, device.flowCount, device.mean)
+	}
+
+	if otherCount > 0 {
+		otherMean := otherTotal / float64(otherCount)
+		series[exporter.OtherDevices] = exporter.DeviceSeries{Mean: otherMean, FlowCount: float64(otherCount)}
+		logDeviceStats(args, exporter.OtherDevices, otherCount, otherMean)
+	}
+
+	promMetrics.Devices.Set(series)
+}
+
+func rankDevices(devices []deviceStats, topBy string) {
+	sort.Slice(devices, func(i, j int) bool {
+		if topBy == "rtt" && devices[i].mean != devices[j].mean {
+			return devices[i].mean > devices[j].mean
+		}
+		if devices[i].flowCount != devices[j].flowCount {
+			return devices[i].flowCount > devices[j].flowCount
+		}
+		return devices[i].device < devices[j].device
+	})
+}
+
+func deviceName(deviceIP string, names map[string]string) string {
+	if name, present := names[deviceIP]; present {
+		return name
+	}
+	return deviceIP
+}
+
+func logDeviceStats(args *loader.Args, device string, flowCount int, mean float64) {
+	if args.StatsOut {
+		fmt.Printf("Device %s: [Flowcount: %d] [LAN Rtt: %f]\n", device, flowCount, mean)
+	}
+}
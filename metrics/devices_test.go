@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"conntrack-lanrtt-analysis/exporter"
+	"conntrack-lanrtt-analysis/loader"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCalculateDeviceStats(t *testing.T) {
+	deviceFlows := map[string][]float64{
+		"192.168.0.10": {2, 4, 6, 8},
+		"192.168.0.11": {50, 70},
+		"192.168.0.12": {3, 5, 7},
+		"192.168.0.13": {100},
+	}
+
+	testCases := []struct {
+		name          string
+		topBy         string
+		expectedMeans map[string]float64
+		expectedFlows map[string]float64
+	}{
+		{
+			name:          "TopByFlows",
+			topBy:         "flows",
+			expectedMeans: map[string]float64{"laptop": 5, "192.168.0.12": 5, "other": 220.0 / 3},
+			expectedFlows: map[string]float64{"laptop": 4, "192.168.0.12": 3, "other": 3},
+		},
+		{
+			name:          "TopByRtt",
+			topBy:         "rtt",
+			expectedMeans: map[string]float64{"192.168.0.13": 100, "192.168.0.11": 60, "other": 5},
+			expectedFlows: map[string]float64{"192.168.0.13": 1, "192.168.0.11": 2, "other": 7},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			promMetrics := exporter.BuildPromMetrics(reg)
+			args := &loader.Args{
+				DeviceMetrics: true,
+				MaxDevices:    2,
+				DeviceTopBy:   tc.topBy,
+				DeviceNames:   map[string]string{"192.168.0.10": "laptop"},
+			}
+
+			CalculateDeviceStats(deviceFlows, args, promMetrics)
+
+			means := gatherDevices(t, reg, "lanRtt_device_mean_value")
+			flows := gatherDevices(t, reg, "lanRtt_device_flows_value")
+			if len(means) != len(tc.expectedMeans) {
+				t.Errorf("Expected %d device series, got %d", len(tc.expectedMeans), len(means))
+			}
+			for device, expectedMean := range tc.expectedMeans {
+				if mean := means[device]; mean != expectedMean {
+					t.Errorf("Expected %s mean %v, got %v", device, expectedMean, mean)
+				}
+				if flowCount := flows[device]; flowCount != tc.expectedFlows[device] {
+					t.Errorf("Expected %s flow count %v, got %v", device, tc.expectedFlows[device], flowCount)
+				}
+			}
+		})
+	}
+}
+
+func TestCalculateDeviceStatsDropsStaleDevices(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	promMetrics := exporter.BuildPromMetrics(reg)
+	args := &loader.Args{DeviceMetrics: true, MaxDevices: 5, DeviceTopBy: "flows"}
+
+	CalculateDeviceStats(map[string][]float64{"192.168.0.10": {5}}, args, promMetrics)
+	CalculateDeviceStats(map[string][]float64{"192.168.0.11": {5}}, args, promMetrics)
+
+	if means := gatherDevices(t, reg, "lanRtt_device_mean_value"); len(means) != 1 || means["192.168.0.11"] != 5 {
+		t.Errorf("Expected only the current period's device, got %v", means)
+	}
+}
+
+// gatherDevices scrapes one per device metric as its values by device label
+func gatherDevices(t *testing.T, reg *prometheus.Registry, name string) map[string]float64 {
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	values := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			values[metric.GetLabel()[0].GetValue()] = metric.GetGauge().GetValue()
+		}
+	}
+	return values
+}
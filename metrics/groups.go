@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"conntrack-lanrtt-analysis/exporter"
+	"conntrack-lanrtt-analysis/loader"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// GroupedSeries breaks the flow metrics down by a label such as address family or subnet. Flows are
+// sketched per group as they are matched, and the group gauges cover the quantile window
+type GroupedSeries struct {
+	label    string
+	key      func(deviceIP string) string
+	window   float64
+	mux      sync.Mutex
+	sketches map[string]*WindowedSketch
+	metrics  exporter.GroupMetrics
+}
+
+func NewGroupedSeries(label string, key func(deviceIP string) string, window float64, groupMetrics exporter.GroupMetrics) *GroupedSeries {
+	return &GroupedSeries{
+		label:    label,
+		key:      key,
+		window:   window,
+		sketches: make(map[string]*WindowedSketch),
+		metrics:  groupMetrics,
+	}
+}
+
+// AddressFamily groups devices into ipv4 and ipv6
+func AddressFamily(deviceIP string) string {
+	if net.ParseIP(deviceIP).To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// SubnetName groups devices by the named subnet they belong to, devices outside every subnet are not grouped
+func SubnetName(subnets []loader.NamedNetwork) func(deviceIP string) string {
+	return func(deviceIP string) string {
+		ip := net.ParseIP(deviceIP)
+		for _, subnet := range subnets {
+			if subnet.Network.Contains(ip) {
+				return subnet.Name
+			}
+		}
+		return ""
+	}
+}
+
+func (g *GroupedSeries) Observe(flow Flow) {
+	group := g.key(flow.DeviceIP)
+	if group == "" {
+		return
+	}
+
+	g.mux.Lock()
+	sketch, present := g.sketches[group]
+	if !present {
+		sketch = NewWindowedSketch(g.window)
+		g.sketches[group] = sketch
+	}
+	g.mux.Unlock()
+
+	sketch.Add(flow.LanRTT, flow.AckTimestamp)
+	g.metrics.Histo.WithLabelValues(group).Observe(flow.LanRTT)
+}
+
+func (g *GroupedSeries) groups() []string {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	groups := make([]string, 0, len(g.sketches))
+	for group := range g.sketches {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+func (g *GroupedSeries) sketch(group string) *WindowedSketch {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	return g.sketches[group]
+}
+
+func CalculateGroups(now time.Time, groups []*GroupedSeries, deviceFlows map[string][]float64, quantiles []float64, statsOut bool) {
+	for _, grouped := range groups {
+		devices := make(map[string]int)
+		for deviceIP := range deviceFlows {
+			if group := grouped.key(deviceIP); group != "" {
+				devices[group]++
+			}
+		}
+
+		for _, group := range grouped.groups() {
+			window := grouped.sketch(group).Snapshot(now)
+
+			grouped.metrics.Mean.WithLabelValues(group).Set(window.Mean())
+			grouped.metrics.Max.WithLabelValues(group).Set(window.Max())
+			grouped.metrics.DeviceCount.WithLabelValues(group).Set(float64(devices[group]))
+			for _, quantile := range quantiles {
+				grouped.metrics.Quantile.WithLabelValues(group, strconv.FormatFloat(quantile, 'f', -1, 64)).Set(window.Quantile(quantile))
+			}
+
+			logGroupStats(statsOut, grouped.label, group, window, devices[group])
+		}
+	}
+}
+
+func logGroupStats(statsOut bool, label, group string, window *Sketch, devicesCount int) {
+	if statsOut {
+		fmt.Printf("%s %s: [Flowcount: %d] [LAN Rtt: %f] [max: %f] [Device Count: %d]\n", label, group, window.Count(), window.Mean(), window.Max(), devicesCount)
+	}
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"conntrack-lanrtt-analysis/exporter"
+	"conntrack-lanrtt-analysis/loader"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSubnetSeries(t *testing.T) {
+	subnets, err := loader.ParseSubnets([]loader.Subnet{
+		{Name: "guest", Network: "192.168.10.0/24"},
+		{Name: "staff", Network: "192.168.20.0/24"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	promMetrics := exporter.BuildPromMetrics(prometheus.NewRegistry())
+	grouped := NewGroupedSeries("Subnet", SubnetName(subnets), 60, promMetrics.Subnet)
+
+	flows := []Flow{
+		{DeviceIP: "192.168.10.5", LanRTT: 10, AckTimestamp: 1},
+		{DeviceIP: "192.168.10.6", LanRTT: 30, AckTimestamp: 2},
+		{DeviceIP: "192.168.20.5", LanRTT: 4, AckTimestamp: 3},
+		{DeviceIP: "10.0.0.1", LanRTT: 500, AckTimestamp: 4},
+	}
+
+	deviceFlows := make(map[string][]float64)
+	for _, flow := range flows {
+		grouped.Observe(flow)
+		deviceFlows[flow.DeviceIP] = append(deviceFlows[flow.DeviceIP], flow.LanRTT)
+	}
+
+	CalculateGroups(time.Now(), []*GroupedSeries{grouped}, deviceFlows, []float64{0.5}, false)
+
+	testCases := []struct {
+		subnet          string
+		expectedMean    float64
+		expectedDevices float64
+	}{
+		{subnet: "guest", expectedMean: 20, expectedDevices: 2},
+		{subnet: "staff", expectedMean: 4, expectedDevices: 1},
+	}
+
+	for _, tc := range testCases {
+		if mean := testutil.ToFloat64(promMetrics.Subnet.Mean.WithLabelValues(tc.subnet)); mean != tc.expectedMean {
+			t.Errorf("Expected %s mean %v, got %v", tc.subnet, tc.expectedMean, mean)
+		}
+		if devices := testutil.ToFloat64(promMetrics.Subnet.DeviceCount.WithLabelValues(tc.subnet)); devices != tc.expectedDevices {
+			t.Errorf("Expected %s device count %v, got %v", tc.subnet, tc.expectedDevices, devices)
+		}
+	}
+
+	if series := testutil.CollectAndCount(promMetrics.Subnet.Mean); series != 2 {
+		t.Errorf("Expected flows outside every subnet to be ungrouped, got %d subnet series", series)
+	}
+}
@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"conntrack-lanrtt-analysis/exporter"
+)
+
+// FlowObserver updates the event-driven metrics (histograms, the quantile sketch) exactly once per
+// matched flow. Snapshot metrics, the gauges over the flow buffer and quantile window, are
+// recalculated from the shared state by ParseFlows every stats period instead
+type FlowObserver struct {
+	sketch      *WindowedSketch
+	groups      []*GroupedSeries
+	promMetrics *exporter.PromMetrics
+}
+
+func NewFlowObserver(sketch *WindowedSketch, promMetrics *exporter.PromMetrics, groups ...*GroupedSeries) *FlowObserver {
+	return &FlowObserver{sketch: sketch, groups: groups, promMetrics: promMetrics}
+}
+
+func (o *FlowObserver) Observe(flow Flow) {
+	o.sketch.Add(flow.LanRTT, flow.AckTimestamp)
+	o.promMetrics.MeanHisto.Observe(flow.LanRTT)
+	for _, grouped := range o.groups {
+		grouped.Observe(flow)
+	}
+}
+
+// Reconfigure swaps the sketch and breakdowns after a config reload. The caller must make sure
+// neither Observe nor a stats update runs at the same time
+func (o *FlowObserver) Reconfigure(sketch *WindowedSketch, groups ...*GroupedSeries) {
+	o.sketch = sketch
+	o.groups = groups
+}
@@ -0,0 +1,175 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	sketchAccuracy = 0.01
+	sketchSlots    = 6
+)
+
+// Sketch is a DDSketch: values are counted in logarithmically sized buckets so any quantile is
+// returned within the relative accuracy of the true value, and two sketches merge by adding counts
+type Sketch struct {
+	gamma     float64
+	logGamma  float64
+	buckets   map[int]uint64
+	zeroCount uint64
+	count     uint64
+	sum       float64
+	max       float64
+}
+
+func NewSketch(relativeAccuracy float64) *Sketch {
+	gamma := (1 + relativeAccuracy) / (1 - relativeAccuracy)
+	return &Sketch{
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		buckets:  make(map[int]uint64),
+	}
+}
+
+func (s *Sketch) Add(value float64) {
+	if value <= 0 {
+		s.zeroCount++
+	} else {
+		s.buckets[int(math.Ceil(math.Log(value)/s.logGamma))]++
+	}
+	if s.count == 0 || value > s.max {
+		s.max = value
+	}
+	s.count++
+	s.sum += value
+}
+
+// Merge adds the counts of other, which must have been created with the same accuracy
+func (s *Sketch) Merge(other *Sketch) {
+	for index, count := range other.buckets {
+		s.buckets[index] += count
+	}
+	if other.count > 0 && (s.count == 0 || other.max > s.max) {
+		s.max = other.max
+	}
+	s.zeroCount += other.zeroCount
+	s.count += other.count
+	s.sum += other.sum
+}
+
+func (s *Sketch) Quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+
+	rank := uint64(q * float64(s.count-1))
+	if rank < s.zeroCount {
+		return 0
+	}
+
+	indexes := make([]int, 0, len(s.buckets))
+	for index := range s.buckets {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	seen := s.zeroCount
+	for _, index := range indexes {
+		seen += s.buckets[index]
+		if seen > rank {
+			return math.Min(2*math.Pow(s.gamma, float64(index))/(s.gamma+1), s.max)
+		}
+	}
+	return s.max
+}
+
+func (s *Sketch) Count() uint64 {
+	return s.count
+}
+
+func (s *Sketch) Mean() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	return s.sum / float64(s.count)
+}
+
+func (s *Sketch) Max() float64 {
+	return s.max
+}
+
+func (s *Sketch) Reset() {
+	s.buckets = make(map[int]uint64)
+	s.zeroCount = 0
+	s.count = 0
+	s.sum = 0
+	s.max = 0
+}
+
+// WindowedSketch splits a window of seconds into slots, each with its own Sketch. Slots are
+// rotated by flow timestamp so Snapshot covers roughly the last window seconds of flows
+type WindowedSketch struct {
+	mux        sync.Mutex
+	slotWidth  float64
+	slots      []*Sketch
+	slotEpochs []int64
+
+	// newest flow timestamp and the wall time it arrived, the flow clock runs on from there
+	latest     float64
+	latestSeen time.Time
+}
+
+func NewWindowedSketch(window float64) *WindowedSketch {
+	windowed := &WindowedSketch{
+		slotWidth:  window / sketchSlots,
+		slots:      make([]*Sketch, sketchSlots),
+		slotEpochs: make([]int64, sketchSlots),
+	}
+	for i := range windowed.slots {
+		windowed.slots[i] = NewSketch(sketchAccuracy)
+	}
+	return windowed
+}
+
+func (w *WindowedSketch) Add(value, timestamp float64) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	epoch := int64(timestamp / w.slotWidth)
+	slot := int(epoch % int64(len(w.slots)))
+
+	if w.slotEpochs[slot] != epoch {
+		w.slots[slot].Reset()
+		w.slotEpochs[slot] = epoch
+	}
+	w.slots[slot].Add(value)
+
+	if timestamp > w.latest {
+		w.latest = timestamp
+		w.latestSeen = time.Now()
+	}
+}
+
+// Snapshot merges every slot still inside the window ending at now. Flow time advances from the
+// newest flow by the wall time since it arrived, so the window empties once flows stop, while a
+// replay keeps its own time line
+func (w *WindowedSketch) Snapshot(now time.Time) *Sketch {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	merged := NewSketch(sketchAccuracy)
+	if w.latestSeen.IsZero() {
+		return merged
+	}
+
+	flowNow := w.latest + now.Sub(w.latestSeen).Seconds()
+	oldest := int64(flowNow/w.slotWidth) - int64(len(w.slots))
+	for i, sketch := range w.slots {
+		if w.slotEpochs[i] > oldest {
+			merged.Merge(sketch)
+		}
+	}
+	return merged
+}
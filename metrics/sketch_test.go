@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSketchQuantile(t *testing.T) {
+	sketch := NewSketch(sketchAccuracy)
+	for i := 1; i <= 10000; i++ {
+		sketch.Add(float64(i) / 10)
+	}
+
+	testCases := []struct {
+		name             string
+		quantile         float64
+		expectedQuantile float64
+	}{
+		{name: "P50", quantile: 0.5, expectedQuantile: 500},
+		{name: "P90", quantile: 0.9, expectedQuantile: 900},
+		{name: "P99", quantile: 0.99, expectedQuantile: 990},
+		{name: "Max", quantile: 1, expectedQuantile: 1000},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			quantile := sketch.Quantile(tc.quantile)
+			if math.Abs(quantile-tc.expectedQuantile) > tc.expectedQuantile*sketchAccuracy {
+				t.Errorf("Expected quantile %v within %v, got %v", tc.expectedQuantile, sketchAccuracy, quantile)
+			}
+		})
+	}
+
+	if sketch.Count() != 10000 {
+		t.Errorf("Expected count 10000, got %v", sketch.Count())
+	}
+	if sketch.Max() != 1000 {
+		t.Errorf("Expected max 1000, got %v", sketch.Max())
+	}
+}
+
+func TestSketchMerge(t *testing.T) {
+	low := NewSketch(sketchAccuracy)
+	high := NewSketch(sketchAccuracy)
+	for i := 1; i <= 100; i++ {
+		low.Add(float64(i))
+		high.Add(float64(i + 100))
+	}
+
+	low.Merge(high)
+
+	if low.Count() != 200 {
+		t.Errorf("Expected merged count 200, got %v", low.Count())
+	}
+	if low.Max() != 200 {
+		t.Errorf("Expected merged max 200, got %v", low.Max())
+	}
+	if median := low.Quantile(0.5); math.Abs(median-100) > 100*sketchAccuracy {
+		t.Errorf("Expected merged median near 100, got %v", median)
+	}
+}
+
+func TestWindowedSketchRotation(t *testing.T) {
+	windowed := NewWindowedSketch(60)
+
+	// slow flows early on, fast flows more than a window later
+	for i := 0; i < 100; i++ {
+		windowed.Add(200, 1000+float64(i)/10)
+	}
+	if max := windowed.Snapshot(time.Now()).Max(); max != 200 {
+		t.Errorf("Expected max 200 inside the window, got %v", max)
+	}
+
+	for i := 0; i < 100; i++ {
+		windowed.Add(5, 1100+float64(i)/10)
+	}
+
+	snapshot := windowed.Snapshot(time.Now())
+	if snapshot.Count() != 100 {
+		t.Errorf("Expected 100 flows inside the window, got %v", snapshot.Count())
+	}
+	if max := snapshot.Max(); max != 5 {
+		t.Errorf("Expected old slots to have rotated out, got max %v", max)
+	}
+}
+
+func TestWindowedSketchIdle(t *testing.T) {
+	windowed := NewWindowedSketch(60)
+	windowed.Add(200, 1000)
+
+	// the window keeps moving once flows stop, so an idle network reports no flows
+	if count := windowed.Snapshot(time.Now().Add(30 * time.Second)).Count(); count != 1 {
+		t.Errorf("Expected the flow inside the window after 30s, got %v flows", count)
+	}
+	if count := windowed.Snapshot(time.Now().Add(2 * time.Minute)).Count(); count != 0 {
+		t.Errorf("Expected the window to be empty 2 minutes after the last flow, got %v flows", count)
+	}
+}
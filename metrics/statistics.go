@@ -3,7 +3,9 @@ package metrics
 import (
 	"conntrack-lanrtt-analysis/exporter"
 	"conntrack-lanrtt-analysis/loader"
+	"context"
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -16,22 +18,100 @@ type Flow struct {
 	LanRTT       float64
 }
 
-func ParseFlows(allFlows *[]Flow, DeviceFlows map[string][]float64, arguments *loader.Args, promMetrics *exporter.PromMetrics, mux *sync.Mutex) {
+// FlowBuffer holds the newest flows with a running RTT total, so the buffer mean is kept
+// as flows come and go rather than summed over every flow each stats period
+type FlowBuffer struct {
+	flows []Flow
+	total float64
+}
 
-	for {
+// Add appends flow, first dropping the oldest flows to make room within size, and returns how many were dropped
+func (b *FlowBuffer) Add(flow Flow, size int) int {
+	dropped := 0
+	for len(b.flows) > 0 && len(b.flows) >= size {
+		b.total -= b.flows[0].LanRTT
+		b.flows = b.flows[1:]
+		dropped++
+	}
+	if len(b.flows) == 0 {
+		b.total = 0
+	}
+
+	b.flows = append(b.flows, flow)
+	b.total += flow.LanRTT
+	return dropped
+}
+
+// Shrink keeps only the newest size flows, releasing the rest, and returns how many were dropped
+func (b *FlowBuffer) Shrink(size int) int {
+	dropped := len(b.flows) - size
+	if dropped <= 0 {
+		return 0
+	}
+
+	for _, flow := range b.flows[:dropped] {
+		b.total -= flow.LanRTT
+	}
+	b.flows = append(make([]Flow, 0, size), b.flows[dropped:]...)
+	if len(b.flows) == 0 {
+		b.total = 0
+	}
+	return dropped
+}
 
-		mux.Lock()
+func (b *FlowBuffer) Len() int {
+	return len(b.flows)
+}
 
-		CalculateAverages(allFlows, arguments, promMetrics, mux)
-		CalculateAggregateAverages(DeviceFlows, arguments, promMetrics, mux)
-		clearDeviceFlows(DeviceFlows)
+func (b *FlowBuffer) Total() float64 {
+	return b.total
+}
 
-		mux.Unlock()
+// Flows are the buffered flows, oldest first
+func (b *FlowBuffer) Flows() []Flow {
+	return b.flows
+}
 
-		time.Sleep(time.Duration(arguments.StatsPeriod) * time.Second)
+// ParseFlows updates the snapshot metrics every stats period until ctx is done, then takes a final snapshot.
+// A statsperiod changed by a reload takes effect from the next tick
+func ParseFlows(ctx context.Context, allFlows *FlowBuffer, DeviceFlows map[string][]float64, observer *FlowObserver, arguments *loader.Args, promMetrics *exporter.PromMetrics, mux *sync.Mutex) {
+
+	statsPeriod := arguments.StatsPeriod
+	ticker := time.NewTicker(time.Duration(statsPeriod) * time.Second)
+	defer ticker.Stop()
+
+	now := time.Now()
+	for {
+
+		if period := updateStats(now, allFlows, DeviceFlows, observer, arguments, promMetrics, mux); period != statsPeriod {
+			statsPeriod = period
+			ticker.Reset(time.Duration(statsPeriod) * time.Second)
+		}
+
+		select {
+		case <-ctx.Done():
+			updateStats(time.Now(), allFlows, DeviceFlows, observer, arguments, promMetrics, mux)
+			return
+		case now = <-ticker.C:
+		}
 	}
 }
 
+// updateStats recalculates the snapshot metrics as of now and returns the stats period they were taken with
+func updateStats(now time.Time, allFlows *FlowBuffer, DeviceFlows map[string][]float64, observer *FlowObserver, arguments *loader.Args, promMetrics *exporter.PromMetrics, mux *sync.Mutex) int {
+
+	mux.Lock()
+	defer mux.Unlock()
+
+	CalculateAverages(now, allFlows, observer.sketch, arguments, promMetrics, mux)
+	CalculateAggregateAverages(DeviceFlows, arguments, promMetrics, mux)
+	CalculateGroups(now, observer.groups, DeviceFlows, arguments.Quantiles, arguments.StatsOut)
+	CalculateDeviceStats(DeviceFlows, arguments, promMetrics)
+	clearDeviceFlows(DeviceFlows)
+
+	return arguments.StatsPeriod
+}
+
 func clearDeviceFlows(deviceFlows map[string][]float64) {
 	for k := range deviceFlows {
 		delete(deviceFlows, k)
@@ -42,28 +122,41 @@ func CalculateFlowRtt(synTimestamp, ackTimestamp float64) float64 {
 	return (ackTimestamp - synTimestamp) * 1000
 }
 
-func CalculateAverages(allFlows *[]Flow, args *loader.Args, promMetrics *exporter.PromMetrics, mux *sync.Mutex) {
-	var delayTotal, mean float64
-
-	for _, flow := range *allFlows {
-		delayTotal += flow.LanRTT
-		promMetrics.MeanHisto.Observe(flow.LanRTT)
+func CalculateAverages(now time.Time, allFlows *FlowBuffer, sketch *WindowedSketch, args *loader.Args, promMetrics *exporter.PromMetrics, mux *sync.Mutex) {
+	var mean float64
 
-	}
-	flowCount := len(*allFlows)
+	// the buffer keeps a running total, the flows are not walked
+	delayTotal := allFlows.Total()
+	flowCount := allFlows.Len()
 
 	if flowCount > 0 {
 		mean = delayTotal / float64(flowCount)
 	}
 
+	// quantiles come from the streaming sketch rather than sorting the whole buffer
+	window := sketch.Snapshot(now)
+
+	quantiles := make([]float64, len(args.Quantiles))
+	for i, quantile := range args.Quantiles {
+		quantiles[i] = window.Quantile(quantile)
+		promMetrics.QuantileAll.WithLabelValues(strconv.FormatFloat(quantile, 'f', -1, 64)).Set(quantiles[i])
+	}
+
+	max := window.Max()
+
 	promMetrics.MeanAll.Set(mean)
+	promMetrics.MaxAll.Set(max)
 
-	logFlowStats(args, delayTotal, flowCount, mean)
+	logFlowStats(args, delayTotal, flowCount, mean, quantiles, max)
 }
 
-func logFlowStats(args *loader.Args, delayTotal float64, flowCount int, mean float64) {
+func logFlowStats(args *loader.Args, delayTotal float64, flowCount int, mean float64, quantiles []float64, max float64) {
 	if args.StatsOut {
-		fmt.Printf("All Flows: [delayTotal: %f] [Flowcount: %d] [LAN Rtt: %f]\n", delayTotal, flowCount, mean)
+		fmt.Printf("All Flows: [delayTotal: %f] [Flowcount: %d] [LAN Rtt: %f]", delayTotal, flowCount, mean)
+		for i, quantile := range args.Quantiles {
+			fmt.Printf(" [p%v: %f]", quantile*100, quantiles[i])
+		}
+		fmt.Printf(" [max: %f]\n", max)
 	}
 }
 
@@ -1,62 +1,28 @@
 package metrics
 
 import (
+	"conntrack-lanrtt-analysis/exporter"
+	"conntrack-lanrtt-analysis/loader"
+	"context"
+	"sync"
 	"testing"
+	"time"
 
-	"github.com/stretchr/testify/mock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 )
 
-// Gauge and Histogram interfaces
-type Gauge interface {
-	Set(float64)
-}
-
-type Histogram interface {
-	Observe(float64)
-}
-
-// MockGauge mocks the Prometheus Gauge
-type MockGauge struct {
-	mock.Mock
-	Value float64
-}
-
-func (m *MockGauge) Set(v float64) {
-	m.Called(v)
-	m.Value = v
-}
-
-// MockHistogram mocks the Prometheus Histogram
-type MockHistogram struct {
-	mock.Mock
-	Observations []float64
-}
-
-func (m *MockHistogram) Observe(v float64) {
-	m.Called(v)
-	m.Observations = append(m.Observations, v)
-}
-
-// MockPromMetrics mocks the PromMetrics struct
-type MockPromMetrics struct {
-	MeanAll             Gauge
-	MeanAggregated      Gauge
-	MeanHisto           Histogram
-	MeanAggregatedHisto Histogram
-	DeviceCount         Gauge
+func histogramCount(t *testing.T, histo prometheus.Histogram) uint64 {
+	metric := &dto.Metric{}
+	if err := histo.Write(metric); err != nil {
+		t.Fatalf("unable to read histogram: %v", err)
+	}
+	return metric.GetHistogram().GetSampleCount()
 }
 
-// Flow struct (as an example, adjust to your actual Flow struct)
-
 func TestCalculateAverages(t *testing.T) {
 
-	//mockPromMetrics := &MockPromMetrics{
-	//	MeanAll:             MockGauge{},
-	//	MeanAggregated:      MockGauge,
-	//	MeanHisto:           Histogram,
-	//	MeanAggregatedHisto: Histogram,
-	//	DeviceCount:         Gauge}
-
 	testCases := []struct {
 		name         string
 		flows        []Flow
@@ -81,28 +47,104 @@ func TestCalculateAverages(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			mockMeanAll := new(MockGauge)
-			mockMeanHisto := new(MockHistogram)
-			//mockPromMetrics := &MockPromMetrics{
-			//	MeanAll:   mockMeanAll,
-			//	MeanHisto: mockMeanHisto,
-			// Initialize other fields as necessary
-			//}
-
-			//args := &loader.Args{} // Initialize as needed
-			//	mux := &sync.Mutex{}
-
-			// Call your CalculateAverages function here
-			//	CalculateAverages(&tc.flows, args, mockPromMetrics, mux)
-
-			// Assertions
-			mockMeanAll.AssertCalled(t, "Set", tc.expectedMean)
+			promMetrics := exporter.BuildPromMetrics(prometheus.NewRegistry())
+			sketch := NewWindowedSketch(60)
+			args := &loader.Args{}
+			mux := &sync.Mutex{}
+
+			var allFlows FlowBuffer
 			for _, flow := range tc.flows {
-				mockMeanHisto.AssertCalled(t, "Observe", flow.LanRTT)
+				allFlows.Add(flow, 10)
 			}
-			if mockMeanAll.Value != tc.expectedMean {
-				t.Errorf("Expected mean %v, got %v", tc.expectedMean, mockMeanAll.Value)
+
+			CalculateAverages(time.Now(), &allFlows, sketch, args, promMetrics, mux)
+
+			if mean := testutil.ToFloat64(promMetrics.MeanAll); mean != tc.expectedMean {
+				t.Errorf("Expected mean %v, got %v", tc.expectedMean, mean)
+			}
+
+			// snapshot calculations must not feed the event-driven histogram
+			if count := histogramCount(t, promMetrics.MeanHisto); count != 0 {
+				t.Errorf("Expected no histogram observations from CalculateAverages, got %v", count)
 			}
 		})
 	}
 }
+
+func TestObserveFlowCountsOnce(t *testing.T) {
+	promMetrics := exporter.BuildPromMetrics(prometheus.NewRegistry())
+	sketch := NewWindowedSketch(60)
+	observer := NewFlowObserver(sketch, promMetrics)
+	args := &loader.Args{}
+	mux := &sync.Mutex{}
+
+	flows := []Flow{
+		{LanRTT: 10, AckTimestamp: 1},
+		{LanRTT: 20, AckTimestamp: 2},
+		{LanRTT: 30, AckTimestamp: 3},
+	}
+
+	var allFlows FlowBuffer
+	for _, flow := range flows {
+		observer.Observe(flow)
+		allFlows.Add(flow, 10)
+	}
+
+	// several stats periods over the same buffer
+	for i := 0; i < 5; i++ {
+		CalculateAverages(time.Now(), &allFlows, sketch, args, promMetrics, mux)
+	}
+
+	if count := histogramCount(t, promMetrics.MeanHisto); count != uint64(len(flows)) {
+		t.Errorf("Expected histogram count %v to equal matched flows, got %v", len(flows), count)
+	}
+	if count := sketch.Snapshot(time.Now()).Count(); count != uint64(len(flows)) {
+		t.Errorf("Expected sketch count %v to equal matched flows, got %v", len(flows), count)
+	}
+}
+
+func TestParseFlowsFinalSnapshot(t *testing.T) {
+	promMetrics := exporter.BuildPromMetrics(prometheus.NewRegistry())
+	observer := NewFlowObserver(NewWindowedSketch(60), promMetrics)
+	args := &loader.Args{StatsPeriod: 3600}
+	mux := &sync.Mutex{}
+
+	var allFlows FlowBuffer
+	deviceFlows := make(map[string][]float64)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ParseFlows(ctx, &allFlows, deviceFlows, observer, args, promMetrics, mux)
+		close(done)
+	}()
+
+	// flows arriving after the last tick must still be in the snapshot taken on shutdown
+	mux.Lock()
+	allFlows.Add(Flow{LanRTT: 10}, 10)
+	allFlows.Add(Flow{LanRTT: 30}, 10)
+	mux.Unlock()
+
+	cancel()
+	<-done
+
+	if mean := testutil.ToFloat64(promMetrics.MeanAll); mean != 20 {
+		t.Errorf("Expected final snapshot mean 20, got %v", mean)
+	}
+}
+
+func TestFlowBuffer(t *testing.T) {
+	var allFlows FlowBuffer
+
+	dropped := 0
+	for i := 1; i <= 5; i++ {
+		dropped += allFlows.Add(Flow{LanRTT: float64(i)}, 3)
+	}
+	if dropped != 2 || allFlows.Len() != 3 || allFlows.Total() != 12 {
+		t.Errorf("Expected flows [3 4 5] with total 12 after 2 evictions, got %v total %v after %v", allFlows.Flows(), allFlows.Total(), dropped)
+	}
+
+	if dropped := allFlows.Shrink(1); dropped != 2 || allFlows.Total() != 5 || allFlows.Flows()[0].LanRTT != 5 {
+		t.Errorf("Expected the newest flow 5 to be kept, got %v total %v after %v", allFlows.Flows(), allFlows.Total(), dropped)
+	}
+}